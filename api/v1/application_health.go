@@ -0,0 +1,66 @@
+/*
+Copyright 2019 dsgkinfo.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// Probe 根据健康检查生成探针, 未指定的检查间隔、超时时间和阈值使用kubernetes的默认值
+func (in *HealthCheck) Probe() *corev1.Probe {
+	probe := &corev1.Probe{
+		InitialDelaySeconds: in.InitialDelaySeconds,
+		PeriodSeconds:       defaultInt32(in.PeriodSeconds, 10),
+		TimeoutSeconds:      defaultInt32(in.TimeoutSeconds, 1),
+		FailureThreshold:    defaultInt32(in.FailureThreshold, 3),
+		SuccessThreshold:    defaultInt32(in.SuccessThreshold, 1),
+	}
+	port := intstr.FromInt(int(in.Port))
+	if in.Type == HealthCheckTCP {
+		probe.Handler.TCPSocket = &corev1.TCPSocketAction{Port: port}
+		return probe
+	}
+	path := in.Path
+	if path == "" {
+		path = "/"
+	}
+	probe.Handler.HTTPGet = &corev1.HTTPGetAction{Path: path, Port: port, Scheme: corev1.URISchemeHTTP}
+	return probe
+}
+
+// ApplyDefaults 为未定义探针的容器设置readiness和liveness探针, 已定义的探针保持不变
+func (in *HealthCheck) ApplyDefaults(container *corev1.Container) {
+	if in == nil {
+		return
+	}
+	if container.ReadinessProbe == nil {
+		container.ReadinessProbe = in.Probe()
+	}
+	if container.LivenessProbe == nil {
+		container.LivenessProbe = in.Probe()
+		// liveness探针的成功阈值只能为1
+		container.LivenessProbe.SuccessThreshold = 1
+	}
+}
+
+func defaultInt32(value, defaultValue int32) int32 {
+	if value > 0 {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2019 dsgkinfo.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"strings"
+)
+
+// module生成的deployment、svc和NetworkPolicy的名称
+func (r *Application) ModuleResourceName(module string) string {
+	if r.Spec.NamingScheme == NamingSchemeAppModule {
+		return r.Name + "-" + module
+	}
+	return module
+}
+
+// 检查module生成的资源名称是否为合法的DNS-1035 label, svc的名称必须满足该规则.
+// modules为空时检查所有module
+func (r *Application) ValidateModuleResourceNames(modules ...string) error {
+	if len(modules) == 0 {
+		for _, module := range r.Spec.Modules {
+			modules = append(modules, module.Name)
+		}
+	}
+	for _, module := range modules {
+		name := r.ModuleResourceName(module)
+		if errs := validation.IsDNS1035Label(name); len(errs) > 0 {
+			return fmt.Errorf("the resource name %s of module %s is invalid: %s", name, module, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
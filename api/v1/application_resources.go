@@ -0,0 +1,157 @@
+/*
+Copyright 2019 dsgkinfo.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ApplyDefaults 为未指定requests/limits的容器设置默认值, 已指定的资源项保持不变
+func (in *ApplicationResources) ApplyDefaults(container *corev1.Container) {
+	if in == nil {
+		return
+	}
+	container.Resources.Requests = mergeResourceList(container.Resources.Requests, in.DefaultRequests)
+	container.Resources.Limits = mergeResourceList(container.Resources.Limits, in.DefaultLimits)
+}
+
+// TotalLimits 按所有module的 replicas × 每个pod的limits 累加计算应用的资源总量.
+// 容器未指定limits时使用默认值, 仍未指定时按requests计算; 初始化容器按k8s的规则与主容器之和取较大值
+func (in *ApplicationSpec) TotalLimits() corev1.ResourceList {
+	total := corev1.ResourceList{}
+	for i := range in.Modules {
+		module := &in.Modules[i]
+		replicas := int64(1)
+		if module.Template.Replicas != nil {
+			replicas = int64(*module.Template.Replicas)
+		}
+		for name, limit := range in.podLimits(&module.Template.Template.Spec) {
+			sum := total[name]
+			sum.Add(*resource.NewMilliQuantity(limit.MilliValue()*replicas, limit.Format))
+			total[name] = sum
+		}
+	}
+	return total
+}
+
+// 计算单个pod的资源limits
+func (in *ApplicationSpec) podLimits(podSpec *corev1.PodSpec) corev1.ResourceList {
+	pod := corev1.ResourceList{}
+	for i := range podSpec.Containers {
+		for name, limit := range in.containerLimits(&podSpec.Containers[i]) {
+			sum := pod[name]
+			sum.Add(limit)
+			pod[name] = sum
+		}
+	}
+	// 初始化容器依次运行, 只需要不超过单个初始化容器的最大值
+	for i := range podSpec.InitContainers {
+		for name, limit := range in.containerLimits(&podSpec.InitContainers[i]) {
+			if current, isExist := pod[name]; !isExist || limit.Cmp(current) > 0 {
+				pod[name] = limit
+			}
+		}
+	}
+	return pod
+}
+
+// 获取容器设置默认值后的limits, 未指定limits的资源使用requests
+func (in *ApplicationSpec) containerLimits(container *corev1.Container) corev1.ResourceList {
+	container = container.DeepCopy()
+	in.Resources.ApplyDefaults(container)
+	return mergeResourceList(container.Resources.Limits, container.Resources.Requests)
+}
+
+// ValidateBudget 校验应用的资源总量是否超出budget, 超出时返回包含明细的错误.
+// 设置了budget时, 所有容器都必须为budget中的资源指定limits或requests, 避免不设置limits绕过budget
+func (in *ApplicationSpec) ValidateBudget() error {
+	if in.Resources == nil || len(in.Resources.Budget) == 0 {
+		return nil
+	}
+	if err := in.validateContainerLimits(); err != nil {
+		return err
+	}
+	total := in.TotalLimits()
+	exceeded := make([]string, 0)
+	for name, budget := range in.Resources.Budget {
+		used, isExist := total[name]
+		if isExist && used.Cmp(budget) > 0 {
+			exceeded = append(exceeded, fmt.Sprintf("%s %s > %s", name, used.String(), budget.String()))
+		}
+	}
+	if len(exceeded) == 0 {
+		return nil
+	}
+	sort.Strings(exceeded)
+	return fmt.Errorf("the resource limits of application exceeded budget: %s", strings.Join(exceeded, ", "))
+}
+
+// 检查所有容器都为budget中的资源设置了limits或requests
+func (in *ApplicationSpec) validateContainerLimits() error {
+	names := make([]string, 0, len(in.Resources.Budget))
+	for name := range in.Resources.Budget {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	for i := range in.Modules {
+		module := &in.Modules[i]
+		podSpec := &module.Template.Template.Spec
+		containers := append(append([]corev1.Container{}, podSpec.InitContainers...), podSpec.Containers...)
+		for j := range containers {
+			limits := in.containerLimits(&containers[j])
+			for _, name := range names {
+				if _, isExist := limits[corev1.ResourceName(name)]; !isExist {
+					return fmt.Errorf("container %s of module %s sets no limits or requests for %s, which are required by the budget", containers[j].Name, module.Name, name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// BudgetChanged 判断更新是否改变了副本数以外的budget相关配置, 只修改replicas时由webhook判断是否为控制器回写的副本数
+func (in *ApplicationSpec) BudgetChanged(old *ApplicationSpec) bool {
+	return !reflect.DeepEqual(in.withoutReplicas(), old.withoutReplicas())
+}
+
+func (in *ApplicationSpec) withoutReplicas() *ApplicationSpec {
+	spec := in.DeepCopy()
+	for i := range spec.Modules {
+		spec.Modules[i].Template.Replicas = nil
+	}
+	return spec
+}
+
+func mergeResourceList(list, defaults corev1.ResourceList) corev1.ResourceList {
+	if len(defaults) == 0 {
+		return list
+	}
+	if list == nil {
+		list = corev1.ResourceList{}
+	}
+	for name, quantity := range defaults {
+		if _, isExist := list[name]; !isExist {
+			list[name] = quantity.DeepCopy()
+		}
+	}
+	return list
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2019 dsgkinfo.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+func newTestContainer(name, cpuRequest, cpuLimit string) corev1.Container {
+	container := corev1.Container{Name: name, Resources: corev1.ResourceRequirements{}}
+	if cpuRequest != "" {
+		container.Resources.Requests = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpuRequest)}
+	}
+	if cpuLimit != "" {
+		container.Resources.Limits = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpuLimit)}
+	}
+	return container
+}
+
+func newTestModule(name string, replicas int32, initContainers []corev1.Container, containers ...corev1.Container) Module {
+	return Module{
+		Name: name,
+		Template: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{InitContainers: initContainers, Containers: containers}},
+		},
+	}
+}
+
+func TestTotalLimits(t *testing.T) {
+	cases := []struct {
+		name      string
+		resources *ApplicationResources
+		modules   []Module
+		expected  string
+	}{
+		{
+			name:     "limits multiplied by replicas",
+			modules:  []Module{newTestModule("web", 2, nil, newTestContainer("web", "", "500m"), newTestContainer("sidecar", "", "100m"))},
+			expected: "1200m",
+		},
+		{
+			name:      "default limits for containers without limits",
+			resources: &ApplicationResources{DefaultLimits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}},
+			modules:   []Module{newTestModule("web", 1, nil, newTestContainer("web", "", "")), newTestModule("api", 1, nil, newTestContainer("api", "", "500m"))},
+			expected:  "1500m",
+		},
+		{
+			name:     "requests for containers without limits",
+			modules:  []Module{newTestModule("web", 3, nil, newTestContainer("web", "200m", ""))},
+			expected: "600m",
+		},
+		{
+			name:     "init containers larger than containers",
+			modules:  []Module{newTestModule("web", 2, []corev1.Container{newTestContainer("init", "", "2")}, newTestContainer("web", "", "500m"))},
+			expected: "4",
+		},
+		{
+			name:     "init containers smaller than containers",
+			modules:  []Module{newTestModule("web", 2, []corev1.Container{newTestContainer("init", "", "100m")}, newTestContainer("web", "", "500m"))},
+			expected: "1",
+		},
+	}
+	for _, c := range cases {
+		spec := &ApplicationSpec{Resources: c.resources, Modules: c.modules}
+		total := spec.TotalLimits()
+		cpu := total[corev1.ResourceCPU]
+		if cpu.Cmp(resource.MustParse(c.expected)) != 0 {
+			t.Errorf("%s: expected total cpu %s, got %s", c.name, c.expected, cpu.String())
+		}
+	}
+}
+
+func TestValidateBudget(t *testing.T) {
+	budget := corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}
+	cases := []struct {
+		name    string
+		modules []Module
+		err     string
+	}{
+		{name: "within budget", modules: []Module{newTestModule("web", 2, nil, newTestContainer("web", "", "500m"))}},
+		{name: "exceeded", modules: []Module{newTestModule("web", 3, nil, newTestContainer("web", "", "500m"))}, err: "exceeded budget: cpu 1500m > 1"},
+		{name: "requests counted", modules: []Module{newTestModule("web", 3, nil, newTestContainer("web", "500m", ""))}, err: "exceeded budget"},
+		{name: "container without limits", modules: []Module{newTestModule("web", 1, nil, newTestContainer("web", "", "500m"), newTestContainer("sidecar", "", ""))}, err: "container sidecar of module web sets no limits"},
+		{name: "init container without limits", modules: []Module{newTestModule("web", 1, []corev1.Container{newTestContainer("init", "", "")}, newTestContainer("web", "", "500m"))}, err: "container init of module web sets no limits"},
+	}
+	for _, c := range cases {
+		spec := &ApplicationSpec{Resources: &ApplicationResources{Budget: budget}, Modules: c.modules}
+		err := spec.ValidateBudget()
+		if c.err == "" && err != nil {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		} else if c.err != "" && (err == nil || !strings.Contains(err.Error(), c.err)) {
+			t.Errorf("%s: expected error containing %q, got %v", c.name, c.err, err)
+		}
+	}
+
+	// 未设置budget时不校验
+	spec := &ApplicationSpec{Modules: []Module{newTestModule("web", 1, nil, newTestContainer("web", "", ""))}}
+	if err := spec.ValidateBudget(); err != nil {
+		t.Errorf("expected no error without budget, got %v", err)
+	}
+}
+
+func TestBudgetChanged(t *testing.T) {
+	spec := &ApplicationSpec{Modules: []Module{newTestModule("web", 1, nil, newTestContainer("web", "", "500m"))}}
+	scaled := spec.DeepCopy()
+	replicas := int32(5)
+	scaled.Modules[0].Template.Replicas = &replicas
+	if scaled.BudgetChanged(spec) {
+		t.Errorf("expected replicas only change not to change budget")
+	}
+	resized := spec.DeepCopy()
+	resized.Modules[0].Template.Template.Spec.Containers[0].Resources.Limits[corev1.ResourceCPU] = resource.MustParse("1")
+	if !resized.BudgetChanged(spec) {
+		t.Errorf("expected limits change to change budget")
+	}
+}
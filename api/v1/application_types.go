@@ -17,14 +17,20 @@ limitations under the License.
 package v1
 
 import (
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
 	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
-var (
-	APPNameLabel    = "app.dsgkinfo.com/appName"
-	ModuleNameLabel = "app.dsgkinfo.com/moduleName"
-	DeploymentType  = "app.dsgkinfo.com/deploymentType"
+// 兼容旧版本引用的标签名称, 新代码请使用applabels包中的定义
+const (
+	// Deprecated: 使用applabels.AppName
+	APPNameLabel = applabels.AppName
+	// Deprecated: 使用applabels.ModuleName
+	ModuleNameLabel = applabels.ModuleName
+	// Deprecated: 使用applabels.DeploymentType
+	DeploymentType = applabels.DeploymentType
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -38,17 +44,99 @@ type ApplicationSpec struct {
 	Description string   `json:"description"`
 	UserID      int      `json:"userID"`
 	Modules     []Module `json:"modules,omitempty"`
+	// 镜像仓库地址, 设置后替换所有module中容器镜像的仓库地址, 优先级高于控制器的全局配置
+	ImageRegistry string `json:"imageRegistry,omitempty"`
+	// 镜像拉取secret, 注入到所有module的pod中
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	// 应用的默认资源配置和资源总量限制
+	Resources *ApplicationResources `json:"resources,omitempty"`
+	// 删除应用时对生成资源的处理方式 {Delete| Orphan| Retain}, 默认为Delete
+	// +kubebuilder:validation:Enum=Delete;Orphan;Retain
+	DeletionPolicy string `json:"deletionPolicy,omitempty"`
+	// 从spec中移除的module先缩容到0, 等待该时间后再删除, 为空或0时立即删除
+	ModuleDeletionGracePeriodSeconds *int64 `json:"moduleDeletionGracePeriodSeconds,omitempty"`
+	// module生成的deployment、svc和NetworkPolicy的命名方式 {Module| AppModule}, 默认为Module
+	// +kubebuilder:validation:Enum=Module;AppModule
+	NamingScheme string `json:"namingScheme,omitempty"`
 }
 
+// module生成资源的命名方式
+var (
+	// 资源名称与module名称相同
+	NamingSchemeModule = "Module"
+	// 资源名称为 <应用名称>-<module名称>, 避免同一namespace中不同应用的module重名
+	NamingSchemeAppModule = "AppModule"
+)
+
+// 应用的删除策略
+var (
+	// 删除应用生成的所有资源
+	DeletionPolicyDelete = "Delete"
+	// 保留deployment和svc, 删除proxy规则和NetworkPolicy
+	DeletionPolicyOrphan = "Orphan"
+	// 保留所有生成的资源, 包括proxy规则和NetworkPolicy
+	DeletionPolicyRetain = "Retain"
+)
+
+// module的访问模式, 决定生成的NetworkPolicy和是否配置ingress proxy
+var (
+	// 只允许同一应用的module访问
+	AccessModePrivate = "private"
+	// 允许同一namespace的pod访问
+	AccessModeNamespace = "namespace"
+	// 允许集群内所有pod访问
+	AccessModeCluster = "cluster"
+	// 允许集群外部通过ingress proxy访问
+	AccessModeOutside = "outside"
+)
+
 type Module struct {
 	Name           string            `json:"name"`
-	AccessMode     string            `json:"accessMode,omitempty"`
+	AccessMode     string            `json:"accessMode,omitempty"` // 访问模式 {private| namespace| cluster| outside}, 为空时不生成NetworkPolicy
 	Proxies        []Proxy           `json:"proxies,omitempty"`
 	ServiceConfigs []ServiceConfig   `json:"serviceConfigs,omitempty"`
 	AppPkgID       string            `json:"appPkgID,omitempty"`
 	Template       v1.DeploymentSpec `json:"template"`
+	// 按容器名称覆盖镜像tag, 用于CI只更新版本而无需提交完整的template
+	ImageTags map[string]string `json:"imageTags,omitempty"`
+	// 删除保护, 从spec中移除后缩容到0, 只有在deployment上添加确认删除注解后才会删除
+	DeletionProtection bool `json:"deletionProtection,omitempty"`
+	// 重命名前的module名称, 新的deployment可用后才迁移proxy规则并删除旧的deployment和svc
+	PreviousName string `json:"previousName,omitempty"`
+	// 引用的ConfigMap或Secret内容变化时滚动更新pod
+	RolloutOnConfigChange bool `json:"rolloutOnConfigChange,omitempty"`
+	// 健康检查, 为主容器生成template中未定义的readiness和liveness探针
+	HealthCheck *HealthCheck `json:"healthCheck,omitempty"`
+}
+
+// module的健康检查, 主容器为与module同名的容器, 没有同名容器时为第一个容器
+type HealthCheck struct {
+	// 检查方式 {http| tcp}, 默认为http
+	// +kubebuilder:validation:Enum=http;tcp
+	Type string `json:"type,omitempty"`
+	// http检查的路径, 默认为/
+	Path string `json:"path,omitempty"`
+	// 检查的容器端口
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port"`
+	// 容器启动后开始检查的等待时间
+	InitialDelaySeconds int32 `json:"initialDelaySeconds,omitempty"`
+	// 检查间隔, 默认10秒
+	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
+	// 检查超时时间, 默认1秒
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+	// 连续失败多少次后判定为未就绪或重启容器, 默认3次
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+	// 连续成功多少次后判定为就绪, 默认1次, 只用于readiness探针
+	SuccessThreshold int32 `json:"successThreshold,omitempty"`
 }
 
+var (
+	HealthCheckHTTP = "http"
+	HealthCheckTCP  = "tcp"
+)
+
 type ServiceConfig struct {
 	ConfigGroup string `json:"configGroup,omitempty"`
 	ConfigItem  string `json:"configItem,omitempty"`
@@ -60,8 +148,40 @@ type Proxy struct {
 	Protocol   string `json:"protocol"`
 	Port       int32  `json:"port"`
 	TargetPort int32  `json:"targetPort"`
+	// 解析客户端请求中的PROXY protocol头, 仅对tcp生效
+	ProxyProtocolDecode bool `json:"proxyProtocolDecode,omitempty"`
+	// 向后端服务发送PROXY protocol头, 仅对tcp生效
+	ProxyProtocolEncode bool `json:"proxyProtocolEncode,omitempty"`
+}
+
+// 应用资源设置, 容器未指定requests/limits时使用默认值, 并限制所有module的资源总量
+type ApplicationResources struct {
+	// 容器未指定requests时使用的默认值
+	DefaultRequests corev1.ResourceList `json:"defaultRequests,omitempty"`
+	// 容器未指定limits时使用的默认值
+	DefaultLimits corev1.ResourceList `json:"defaultLimits,omitempty"`
+	// 应用的资源总量上限, 按所有module的 replicas × limits 累加计算
+	Budget corev1.ResourceList `json:"budget,omitempty"`
 }
 
+// 应用状态条件
+type ApplicationCondition struct {
+	Type               string                 `json:"type"`
+	Status             corev1.ConditionStatus `json:"status"`
+	Reason             string                 `json:"reason,omitempty"`
+	Message            string                 `json:"message,omitempty"`
+	LastTransitionTime metav1.Time            `json:"lastTransitionTime,omitempty"`
+}
+
+var (
+	// 应用的资源总量超出budget
+	ConditionBudgetExceeded = "BudgetExceeded"
+	// 应用所在的namespace未授权给应用的用户
+	ConditionNamespaceForbidden = "NamespaceForbidden"
+	// module生成的资源名称不合法, 未开启webhook时由控制器记录
+	ConditionInvalidResourceName = "InvalidResourceName"
+)
+
 // ApplicationStatus defines the observed state of Application
 type ApplicationStatus struct {
 	TotalModuleNumber    int32  `json:"totalModuleNumber,omitempty"`
@@ -69,9 +189,66 @@ type ApplicationStatus struct {
 	StartingModuleNumber int32  `json:"startingModuleNumber,omitempty"`
 	StoppedModuleNumber  int32  `json:"stoppedModuleNumber,omitempty"`
 	RollingUpdateNumber  int32  `json:"rollingUpdateNumber,omitempty"`
-	Status               string `json:"status,omitempty"` // 应用状态 {Running| Stopped}
+	Status               string `json:"status,omitempty"` // 应用状态 {Running| Progressing| Starting| Stopped}
+
+	// 应用状态条件, 如资源总量超出限制等
+	Conditions []ApplicationCondition `json:"conditions,omitempty"`
+	// module状态, 包括已从spec中移除但尚未删除的module
+	Modules []ModuleStatus `json:"modules,omitempty"`
+}
+
+// module状态
+type ModuleStatus struct {
+	Name  string `json:"name"`
+	Phase string `json:"phase,omitempty"`
+	// 待删除module的删除时间, 需要确认删除时为空
+	DeleteAfter *metav1.Time `json:"deleteAfter,omitempty"`
+	// module生成的deployment、svc和NetworkPolicy的名称
+	ResourceName string `json:"resourceName,omitempty"`
+	// 最近一次全部更新完成的容器镜像, 多个容器以逗号分隔
+	Image string `json:"image,omitempty"`
+	// 正在进行的滚动更新, 更新完成后清空
+	Rollout *ModuleRollout `json:"rollout,omitempty"`
+	// 根据pod的Ready状态汇总的健康检查结果, 控制器开启汇总时记录
+	Health *ModuleHealth `json:"health,omitempty"`
+}
+
+// module的健康检查结果
+type ModuleHealth struct {
+	ReadyPods int32 `json:"readyPods"`
+	TotalPods int32 `json:"totalPods"`
+	// 最近一次发生变化的pod就绪状态 {Ready| NotReady}
+	LastProbeResult string `json:"lastProbeResult,omitempty"`
+	// 最近一次就绪状态变化的时间
+	LastProbeTime *metav1.Time `json:"lastProbeTime,omitempty"`
+	// 未就绪的原因
+	Message string `json:"message,omitempty"`
 }
 
+var (
+	ProbeResultReady    = "Ready"
+	ProbeResultNotReady = "NotReady"
+)
+
+// module正在进行的滚动更新
+type ModuleRollout struct {
+	// 更新前的容器镜像, 控制器未记录时为空
+	OldImage string `json:"oldImage,omitempty"`
+	// 更新后的容器镜像
+	NewImage  string      `json:"newImage"`
+	StartedAt metav1.Time `json:"startedAt"`
+}
+
+var (
+	ModulePhaseRunning  = "Running"
+	ModulePhaseStarting = "Starting"
+	ModulePhaseStopped  = "Stopped"
+	// 有可用副本, 但deployment的滚动更新尚未完成
+	ModulePhaseProgressing = "Progressing"
+	// module已从spec中移除, 缩容到0等待删除
+	ModulePhasePendingDeletion = "PendingDeletion"
+)
+
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
 // +kubebuilder:resource:shortName=app
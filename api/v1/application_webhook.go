@@ -0,0 +1,282 @@
+/*
+Copyright 2019 dsgkinfo.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
+	"k8s.io/api/admission/v1beta1"
+	v1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"net/http"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// log is for logging in this package.
+var applicationlog = logf.Log.WithName("application-resource")
+
+var validateApplicationPath = "/validate-app-dsgkinfo-com-v1-application"
+
+// 注册校验应用的webhook, 使用manager的client查询同一namespace中的应用和deployment
+func (r *Application) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	mgr.GetWebhookServer().Register(validateApplicationPath, &webhook.Admission{Handler: &applicationValidator{client: mgr.GetClient()}})
+	return nil
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-app-dsgkinfo-com-v1-application,mutating=false,failurePolicy=fail,groups=app.dsgkinfo.com,resources=applications,versions=v1,name=vapplication.kb.io
+
+var _ webhook.Validator = &Application{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Application) ValidateCreate() error {
+	applicationlog.Info("validate create", "name", r.Name)
+
+	return r.validateApplication(nil, nil)
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Application) ValidateUpdate(old runtime.Object) error {
+	applicationlog.Info("validate update", "name", r.Name)
+
+	oldApp, _ := old.(*Application)
+	return r.validateApplication(oldApp, nil)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Application) ValidateDelete() error {
+	return nil
+}
+
+// 校验应用, 更新时old为更新前的应用, 创建时为nil. liveReplicas为集群中module的deployment的副本数, key为资源名称
+func (r *Application) validateApplication(old *Application, liveReplicas map[string]int32) error {
+	// 删除中的应用只会更新finalizers, 不做校验
+	if !r.DeletionTimestamp.IsZero() {
+		return nil
+	}
+	if err := r.Spec.ValidateModuleNames(); err != nil {
+		return err
+	}
+	// 只修改metadata, 或者只是控制器回写HPA扩缩容后deployment的副本数时不重新校验budget,
+	// 避免HPA扩容超出budget后控制器无法回写副本数和finalizers. 其他副本数的修改仍然校验budget
+	if old != nil && !r.Spec.BudgetChanged(&old.Spec) && r.isReplicasSynced(old, liveReplicas) {
+		return nil
+	}
+	return r.Spec.ValidateBudget()
+}
+
+// 判断与更新前相比副本数发生变化的module, 其副本数是否都与集群中的deployment一致
+func (r *Application) isReplicasSynced(old *Application, liveReplicas map[string]int32) bool {
+	oldReplicas := make(map[string]int32)
+	for i := range old.Spec.Modules {
+		oldReplicas[old.Spec.Modules[i].Name] = moduleReplicas(&old.Spec.Modules[i])
+	}
+	for i := range r.Spec.Modules {
+		module := &r.Spec.Modules[i]
+		replicas := moduleReplicas(module)
+		if found, isExist := oldReplicas[module.Name]; isExist && found == replicas {
+			continue
+		}
+		if live, isExist := liveReplicas[r.ModuleResourceName(module.Name)]; !isExist || live != replicas {
+			return false
+		}
+	}
+	return true
+}
+
+// module的副本数, 未指定时与deployment一样默认为1
+func moduleReplicas(module *Module) int32 {
+	if module.Template.Replicas == nil {
+		return 1
+	}
+	return *module.Template.Replicas
+}
+
+// 校验应用的admission handler, 在Validator的校验之外通过client检查module资源名称与namespace中已有资源的冲突
+type applicationValidator struct {
+	client  client.Client
+	decoder *admission.Decoder
+}
+
+var _ admission.DecoderInjector = &applicationValidator{}
+
+// InjectDecoder injects the decoder into the applicationValidator.
+func (v *applicationValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
+
+// Handle handles admission requests.
+func (v *applicationValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	app := &Application{}
+	if err := v.decoder.Decode(req, app); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	var old *Application
+	var err error
+	switch req.Operation {
+	case v1beta1.Create:
+		err = app.ValidateCreate()
+	case v1beta1.Update:
+		old = &Application{}
+		if err := v.decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		applicationlog.Info("validate update", "name", app.Name)
+		liveReplicas, listErr := v.liveReplicas(ctx, app, old)
+		if listErr != nil {
+			return admission.Errored(http.StatusInternalServerError, listErr)
+		}
+		err = app.validateApplication(old, liveReplicas)
+	default:
+		return admission.Allowed("")
+	}
+	if err != nil {
+		return admission.Denied(err.Error())
+	}
+	return v.validateResourceNameCollisions(ctx, app, old)
+}
+
+// 只修改了副本数的更新, 读取集群中module的deployment的副本数, 用于判断是否为控制器回写的副本数
+func (v *applicationValidator) liveReplicas(ctx context.Context, app, old *Application) (map[string]int32, error) {
+	if app.Spec.BudgetChanged(&old.Spec) {
+		return nil, nil
+	}
+	deploymentList := &v1.DeploymentList{}
+	if err := v.client.List(ctx, deploymentList, client.InNamespace(app.Namespace)); err != nil {
+		applicationlog.Error(err, "failed to list deployments.", "namespace", app.Namespace)
+		return nil, err
+	}
+	replicas := make(map[string]int32)
+	for _, deploy := range deploymentList.Items {
+		if deploy.Spec.Replicas != nil && metav1.IsControlledBy(&deploy, app) {
+			replicas[deploy.Name] = *deploy.Spec.Replicas
+		}
+	}
+	return replicas, nil
+}
+
+// 只检查新生成的资源名称, 已存在的冲突不影响应用的其他更新
+func (v *applicationValidator) validateResourceNameCollisions(ctx context.Context, app, old *Application) admission.Response {
+	if !app.DeletionTimestamp.IsZero() || len(app.newModules(old)) == 0 {
+		return admission.Allowed("")
+	}
+	apps := &ApplicationList{}
+	if err := v.client.List(ctx, apps, client.InNamespace(app.Namespace)); err != nil {
+		applicationlog.Error(err, "failed to list applications.", "namespace", app.Namespace)
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	deploymentList := &v1.DeploymentList{}
+	if err := v.client.List(ctx, deploymentList, client.InNamespace(app.Namespace)); err != nil {
+		applicationlog.Error(err, "failed to list deployments.", "namespace", app.Namespace)
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	if err := app.ValidateResourceNames(apps.Items, deploymentList.Items, old); err != nil {
+		return admission.Denied(err.Error())
+	}
+	return admission.Allowed("")
+}
+
+// 与更新前相比资源名称发生变化的module, 包括新增的module和修改命名方式或重命名的module, 创建时为所有module
+func (r *Application) newModules(old *Application) []string {
+	existing := make(map[string]bool)
+	if old != nil {
+		for _, module := range old.Spec.Modules {
+			existing[old.ModuleResourceName(module.Name)] = true
+		}
+	}
+	var modules []string
+	for _, module := range r.Spec.Modules {
+		if !existing[r.ModuleResourceName(module.Name)] {
+			modules = append(modules, module.Name)
+		}
+	}
+	return modules
+}
+
+// 检查新生成的module资源名称是否合法, 以及是否与同一namespace中其他应用的module或不属于本应用的deployment冲突
+// 没有controller的deployment只有在应用设置了接管注解时才允许使用
+func (r *Application) ValidateResourceNames(others []Application, deployments []v1.Deployment, old *Application) error {
+	used := make(map[string]string)
+	for i := range others {
+		other := &others[i]
+		if other.Name == r.Name {
+			continue
+		}
+		for _, module := range other.Spec.Modules {
+			used[other.ModuleResourceName(module.Name)] = fmt.Sprintf("module %s of application %s", module.Name, other.Name)
+		}
+	}
+	if err := r.ValidateModuleResourceNames(r.newModules(old)...); err != nil {
+		return err
+	}
+	found := make(map[string]*v1.Deployment)
+	for i := range deployments {
+		found[deployments[i].Name] = &deployments[i]
+	}
+	for _, module := range r.newModules(old) {
+		name := r.ModuleResourceName(module)
+		if usedBy, isExist := used[name]; isExist {
+			return fmt.Errorf("the resource name %s of module %s is already used by %s, set namingScheme to %s to avoid the collision", name, module, usedBy, NamingSchemeAppModule)
+		}
+		deploy, isExist := found[name]
+		if !isExist {
+			continue
+		}
+		owner := metav1.GetControllerOf(deploy)
+		if owner == nil {
+			if r.Annotations[applabels.AdoptAnnotation] != "true" {
+				return fmt.Errorf("the resource name %s of module %s is already used by an existing deployment, set annotation %s=true to adopt it or set namingScheme to %s to avoid the collision", name, module, applabels.AdoptAnnotation, NamingSchemeAppModule)
+			}
+			continue
+		}
+		if owner.Kind != "Application" || owner.Name != r.Name {
+			return fmt.Errorf("the resource name %s of module %s is already used by a deployment controlled by %s %s, set namingScheme to %s to avoid the collision", name, module, owner.Kind, owner.Name, NamingSchemeAppModule)
+		}
+	}
+	return nil
+}
+
+// 检查module名称不重复, 且重命名前的名称不能被其他module使用
+func (s *ApplicationSpec) ValidateModuleNames() error {
+	names := make(map[string]bool)
+	for _, module := range s.Modules {
+		if names[module.Name] {
+			return fmt.Errorf("duplicated module name %s", module.Name)
+		}
+		names[module.Name] = true
+	}
+	previousNames := make(map[string]bool)
+	for _, module := range s.Modules {
+		if module.PreviousName == "" || module.PreviousName == module.Name {
+			continue
+		}
+		if names[module.PreviousName] {
+			return fmt.Errorf("the previous name %s of module %s is used by another module", module.PreviousName, module.Name)
+		}
+		if previousNames[module.PreviousName] {
+			return fmt.Errorf("duplicated previous name %s", module.PreviousName)
+		}
+		previousNames[module.PreviousName] = true
+	}
+	return nil
+}
@@ -0,0 +1,271 @@
+/*
+Copyright 2019 dsgkinfo.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
+	"k8s.io/api/admission/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func newTestApplication(modules ...Module) *Application {
+	return &Application{
+		ObjectMeta: metav1.ObjectMeta{Name: "wsgw", Namespace: "default"},
+		Spec: ApplicationSpec{
+			UserID:    2,
+			Resources: &ApplicationResources{Budget: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}},
+			Modules:   modules,
+		},
+	}
+}
+
+func TestValidateCreateBudget(t *testing.T) {
+	if err := newTestApplication(newTestModule("web", 2, nil, newTestContainer("web", "", "500m"))).ValidateCreate(); err != nil {
+		t.Errorf("expected app within budget to be accepted, got %v", err)
+	}
+	if err := newTestApplication(newTestModule("web", 3, nil, newTestContainer("web", "", "500m"))).ValidateCreate(); err == nil {
+		t.Errorf("expected app exceeding budget to be rejected")
+	}
+	if err := newTestApplication(newTestModule("web", 1, nil, newTestContainer("web", "", "500m")), newTestModule("web", 1, nil, newTestContainer("web", "", "500m"))).ValidateCreate(); err == nil {
+		t.Errorf("expected duplicated module names to be rejected")
+	}
+}
+
+func TestValidateUpdateBudget(t *testing.T) {
+	old := newTestApplication(newTestModule("web", 2, nil, newTestContainer("web", "", "500m")))
+
+	// 没有集群中deployment的副本数时, 超出budget的副本数修改被拒绝
+	scaled := old.DeepCopy()
+	replicas := int32(4)
+	scaled.Spec.Modules[0].Template.Replicas = &replicas
+	if err := scaled.ValidateUpdate(old); err == nil {
+		t.Errorf("expected replicas change exceeding budget to be rejected")
+	}
+	// HPA扩容后控制器回写与deployment一致的副本数, 超出budget也不拒绝
+	if err := scaled.validateApplication(old, map[string]int32{"web": 4}); err != nil {
+		t.Errorf("expected replicas back sync to be accepted, got %v", err)
+	}
+	if err := scaled.validateApplication(old, map[string]int32{"web": 2}); err == nil {
+		t.Errorf("expected replicas different from the deployment to be rejected")
+	}
+	// 已超出budget的应用仍然可以添加finalizer
+	finalized := scaled.DeepCopy()
+	finalized.Finalizers = []string{"application.finalizers.dsgkinfo.com"}
+	if err := finalized.ValidateUpdate(scaled); err != nil {
+		t.Errorf("expected metadata update to be accepted, got %v", err)
+	}
+
+	resized := old.DeepCopy()
+	resized.Spec.Modules[0].Template.Template.Spec.Containers[0].Resources.Limits[corev1.ResourceCPU] = resource.MustParse("1")
+	if err := resized.ValidateUpdate(old); err == nil {
+		t.Errorf("expected limits change exceeding budget to be rejected")
+	}
+
+	// 删除中的应用不做校验
+	deleting := resized.DeepCopy()
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+	if err := deleting.ValidateUpdate(resized); err != nil {
+		t.Errorf("expected deleting app to be accepted, got %v", err)
+	}
+}
+
+func newTestDeployment(name string, owner *metav1.OwnerReference) appsv1.Deployment {
+	deploy := appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}}
+	if owner != nil {
+		deploy.OwnerReferences = []metav1.OwnerReference{*owner}
+	}
+	return deploy
+}
+
+func newTestOwner(kind, name string) *metav1.OwnerReference {
+	isController := true
+	return &metav1.OwnerReference{APIVersion: GroupVersion.String(), Kind: kind, Name: name, Controller: &isController}
+}
+
+func TestValidateResourceNames(t *testing.T) {
+	other := newTestApplication(newTestModule("web", 1, nil, newTestContainer("web", "", "500m")))
+	other.Name = "portal"
+	cases := []struct {
+		name        string
+		app         func() *Application
+		old         *Application
+		deployments []appsv1.Deployment
+		expected    string
+	}{
+		{
+			name:     "module of other application",
+			app:      func() *Application { return newTestApplication(newTestModule("web", 1, nil)) },
+			expected: "module web of application portal",
+		},
+		{
+			name: "app module naming scheme",
+			app: func() *Application {
+				app := newTestApplication(newTestModule("web", 1, nil))
+				app.Spec.NamingScheme = NamingSchemeAppModule
+				return app
+			},
+		},
+		{
+			name: "generated name too long",
+			app: func() *Application {
+				app := newTestApplication(newTestModule("web", 1, nil))
+				app.Name = strings.Repeat("a", 60)
+				app.Spec.NamingScheme = NamingSchemeAppModule
+				return app
+			},
+			expected: "is invalid",
+		},
+		{
+			name:     "generated name not a DNS-1035 label",
+			app:      func() *Application { return newTestApplication(newTestModule("1web", 1, nil)) },
+			expected: "is invalid",
+		},
+		{
+			name:        "deployment without controller",
+			app:         func() *Application { return newTestApplication(newTestModule("api", 1, nil)) },
+			deployments: []appsv1.Deployment{newTestDeployment("api", nil)},
+			expected:    "already used by an existing deployment",
+		},
+		{
+			name: "deployment without controller to adopt",
+			app: func() *Application {
+				app := newTestApplication(newTestModule("api", 1, nil))
+				app.Annotations = map[string]string{applabels.AdoptAnnotation: "true"}
+				return app
+			},
+			deployments: []appsv1.Deployment{newTestDeployment("api", nil)},
+		},
+		{
+			name:        "deployment controlled by other controller",
+			app:         func() *Application { return newTestApplication(newTestModule("api", 1, nil)) },
+			deployments: []appsv1.Deployment{newTestDeployment("api", newTestOwner("ReplicaSet", "api"))},
+			expected:    "controlled by ReplicaSet api",
+		},
+		{
+			name:        "deployment controlled by the application",
+			app:         func() *Application { return newTestApplication(newTestModule("api", 1, nil)) },
+			deployments: []appsv1.Deployment{newTestDeployment("api", newTestOwner("Application", "wsgw"))},
+		},
+		{
+			// 升级前已存在的冲突不影响应用的其他更新
+			name:        "existing collision of unchanged module",
+			app:         func() *Application { return newTestApplication(newTestModule("web", 2, nil)) },
+			old:         newTestApplication(newTestModule("web", 1, nil)),
+			deployments: []appsv1.Deployment{newTestDeployment("web", newTestOwner("Application", "portal"))},
+		},
+		{
+			name: "new module added by update",
+			app: func() *Application {
+				return newTestApplication(newTestModule("api", 1, nil), newTestModule("web", 1, nil))
+			},
+			old:      newTestApplication(newTestModule("api", 1, nil)),
+			expected: "module web of application portal",
+		},
+	}
+	for _, c := range cases {
+		err := c.app().ValidateResourceNames([]Application{*other}, c.deployments, c.old)
+		if c.expected == "" && err != nil {
+			t.Errorf("%s: expected no error, got %v", c.name, err)
+		}
+		if c.expected != "" && (err == nil || !strings.Contains(err.Error(), c.expected)) {
+			t.Errorf("%s: expected error containing %q, got %v", c.name, c.expected, err)
+		}
+	}
+}
+
+func newTestAdmissionRequest(t *testing.T, operation v1beta1.Operation, app, old *Application) admission.Request {
+	req := admission.Request{AdmissionRequest: v1beta1.AdmissionRequest{Operation: operation}}
+	raw, err := json.Marshal(app)
+	if err != nil {
+		t.Fatalf("failed to marshal app: %v", err)
+	}
+	req.Object.Raw = raw
+	if old != nil {
+		if req.OldObject.Raw, err = json.Marshal(old); err != nil {
+			t.Fatalf("failed to marshal old app: %v", err)
+		}
+	}
+	return req
+}
+
+func TestApplicationValidatorHandle(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = AddToScheme(scheme)
+	decoder, err := admission.NewDecoder(scheme)
+	if err != nil {
+		t.Fatalf("failed to create decoder: %v", err)
+	}
+	isController := true
+	deploy := newTestDeployment("web", nil)
+	v := &applicationValidator{client: fake.NewFakeClientWithScheme(scheme, &deploy)}
+	if err := v.InjectDecoder(decoder); err != nil {
+		t.Fatalf("failed to inject decoder: %v", err)
+	}
+
+	old := newTestApplication(newTestModule("web", 1, nil, newTestContainer("web", "", "500m")))
+	if resp := v.Handle(context.TODO(), newTestAdmissionRequest(t, v1beta1.Create, old, nil)); resp.Allowed {
+		t.Errorf("expected create colliding with existing deployment to be denied")
+	}
+	app := old.DeepCopy()
+	replicas := int32(2)
+	app.Spec.Modules[0].Template.Replicas = &replicas
+	if resp := v.Handle(context.TODO(), newTestAdmissionRequest(t, v1beta1.Update, app, old)); !resp.Allowed {
+		t.Errorf("expected update of unchanged module names to be allowed, got %v", resp.Result)
+	}
+	renamed := app.DeepCopy()
+	renamed.Spec.NamingScheme = NamingSchemeAppModule
+	if resp := v.Handle(context.TODO(), newTestAdmissionRequest(t, v1beta1.Update, renamed, app)); !resp.Allowed {
+		t.Errorf("expected update to app module naming scheme to be allowed, got %v", resp.Result)
+	}
+	// 集群中的deployment由HPA扩容到4个副本
+	owned := newTestDeployment("web", &metav1.OwnerReference{APIVersion: GroupVersion.String(), Kind: "Application", Name: "wsgw", UID: "wsgw-uid", Controller: &isController})
+	scaledReplicas := int32(4)
+	owned.Spec.Replicas = &scaledReplicas
+	v.client = fake.NewFakeClientWithScheme(scheme, &owned)
+	app.UID = "wsgw-uid"
+	synced := app.DeepCopy()
+	synced.Spec.Modules[0].Template.Replicas = &scaledReplicas
+	if resp := v.Handle(context.TODO(), newTestAdmissionRequest(t, v1beta1.Update, synced, app)); !resp.Allowed {
+		t.Errorf("expected replicas back sync to be allowed, got %v", resp.Result)
+	}
+	manual := app.DeepCopy()
+	manualReplicas := int32(500)
+	manual.Spec.Modules[0].Template.Replicas = &manualReplicas
+	if resp := v.Handle(context.TODO(), newTestAdmissionRequest(t, v1beta1.Update, manual, app)); resp.Allowed {
+		t.Errorf("expected replicas change exceeding budget to be denied")
+	}
+
+	exceeded := app.DeepCopy()
+	exceeded.Spec.Modules[0].Template.Template.Spec.Containers[0].Resources.Limits[corev1.ResourceCPU] = resource.MustParse("1")
+	if resp := v.Handle(context.TODO(), newTestAdmissionRequest(t, v1beta1.Update, exceeded, app)); resp.Allowed {
+		t.Errorf("expected update exceeding budget to be denied")
+	}
+}
@@ -0,0 +1,224 @@
+/**
+ * 功能描述: 将集群中已有的deployment和svc纳入application管理
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	"fmt"
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
+	"io"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func isAdopting(app *appv1.Application) bool {
+	return app.Annotations[applabels.AdoptAnnotation] == "true"
+}
+
+// 处理集群中已存在但不受当前应用控制的deployment.
+// 应用开启接管时, 为deployment设置controller reference和控制器需要的标签, 并将同名svc的标签补齐;
+// 否则只记录事件, 不修改该deployment
+func (r *ApplicationReconciler) adoptDeployment(ctx context.Context, app *appv1.Application, module *appv1.Module, deploy, found *v1.Deployment) error {
+	if owner := metav1.GetControllerOf(found); owner != nil {
+		log.Info("the deployment is controlled by others, skip it.", "namespace", found.Namespace, "name", found.Name, "owner", owner.Kind+"/"+owner.Name)
+		r.recordModuleEvent(app, module.Name, corev1.EventTypeWarning, ReasonAdoptionSkipped, "deployment %s is already controlled by %s %s", found.Name, owner.Kind, owner.Name)
+		return nil
+	}
+	if !isAdopting(app) {
+		log.Info("the deployment is not managed by application, skip it.", "namespace", found.Namespace, "name", found.Name)
+		r.recordModuleEvent(app, module.Name, corev1.EventTypeWarning, ReasonAdoptionSkipped, "deployment %s already exists, set annotation %s=true to adopt it", found.Name, applabels.AdoptAnnotation)
+		return nil
+	}
+
+	if found.Labels == nil {
+		found.Labels = make(map[string]string)
+	}
+	for key, value := range deploy.Labels {
+		found.Labels[key] = value
+	}
+	if err := controllerutil.SetControllerReference(app, found, r.Scheme); err != nil {
+		log.Error(err, "failed to set Owner reference for adopted deployment", "moduleName", module.Name)
+		return err
+	}
+	// selector不可修改, 保留集群中的selector
+	selector := found.Spec.Selector
+	found.Spec = deploy.Spec
+	found.Spec.Selector = selector
+	if err := r.Update(ctx, found); err != nil {
+		log.Error(err, "failed to adopt deployment.", "namespace", found.Namespace, "name", found.Name)
+		return err
+	}
+
+	// svc没有owner reference, 补齐标签即可, spec由reconcileSvc调谐
+	svc := &corev1.Service{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: found.Namespace, Name: found.Name}, svc)
+	if err != nil && !apierrs.IsNotFound(err) {
+		log.Error(err, "failed to get svc for adoption.", "namespace", found.Namespace, "name", found.Name)
+		return err
+	} else if err == nil {
+		if svc.Labels == nil {
+			svc.Labels = make(map[string]string)
+		}
+		for key, value := range deploy.Labels {
+			svc.Labels[key] = value
+		}
+		if err := r.Update(ctx, svc); err != nil {
+			log.Error(err, "failed to adopt svc.", "namespace", svc.Namespace, "name", svc.Name)
+			return err
+		}
+	}
+
+	log.Info("adopted the existing deployment.", "namespace", found.Namespace, "name", found.Name)
+	r.recordModuleEvent(app, module.Name, corev1.EventTypeNormal, ReasonModuleAdopted, "adopted deployment %s", found.Name)
+	return nil
+}
+
+// 从yaml或json中读取deployment和svc, 支持以---分隔的多个文档以及kubectl get -o yaml输出的List
+func DecodeWorkloads(reader io.Reader) ([]v1.Deployment, []corev1.Service, error) {
+	deploys := make([]v1.Deployment, 0)
+	svcs := make([]corev1.Service, 0)
+	add := func(object runtime.Object) error {
+		u := object.(*unstructured.Unstructured)
+		switch u.GetKind() {
+		case "Deployment":
+			deploy := v1.Deployment{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &deploy); err != nil {
+				return err
+			}
+			deploys = append(deploys, deploy)
+		case "Service":
+			svc := corev1.Service{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, &svc); err != nil {
+				return err
+			}
+			svcs = append(svcs, svc)
+		default:
+			return fmt.Errorf("unsupported kind %s of %s, only Deployment and Service can be adopted", u.GetKind(), u.GetName())
+		}
+		return nil
+	}
+
+	decoder := utilyaml.NewYAMLOrJSONDecoder(reader, 4096)
+	for {
+		u := &unstructured.Unstructured{}
+		if err := decoder.Decode(u); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+		// 跳过空文档
+		if len(u.Object) == 0 {
+			continue
+		}
+		var err error
+		if u.IsList() {
+			err = u.EachListItem(add)
+		} else {
+			err = add(u)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return deploys, svcs, nil
+}
+
+// 根据已有的deployment和svc生成开启接管的application, 每个deployment对应一个module.
+// 返回无法完全保留的配置的提示信息
+func BuildApplicationFromWorkloads(name, namespace string, userID int, deploys []v1.Deployment, svcs []corev1.Service) (*appv1.Application, []string, error) {
+	warnings := make([]string, 0)
+	app := &appv1.Application{
+		TypeMeta: metav1.TypeMeta{APIVersion: appv1.GroupVersion.String(), Kind: "Application"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Annotations: map[string]string{applabels.AdoptAnnotation: "true"},
+		},
+		Spec: appv1.ApplicationSpec{
+			DisplayName: name,
+			UserID:      userID,
+			Modules:     make([]appv1.Module, 0, len(deploys)),
+		},
+	}
+
+	modules := make(map[string]*appv1.Module)
+	for _, deploy := range deploys {
+		if app.Namespace == "" {
+			app.Namespace = deploy.Namespace
+		}
+		if deploy.Namespace != "" && deploy.Namespace != app.Namespace {
+			return nil, nil, fmt.Errorf("deployment %s/%s is not in namespace %s", deploy.Namespace, deploy.Name, app.Namespace)
+		}
+		if owner := metav1.GetControllerOf(&deploy); owner != nil {
+			return nil, nil, fmt.Errorf("deployment %s is already controlled by %s %s", deploy.Name, owner.Kind, owner.Name)
+		}
+		template := *deploy.Spec.DeepCopy()
+		// 控制器生成的svc通过name标签选择pod
+		if template.Template.Labels == nil {
+			template.Template.Labels = make(map[string]string)
+		}
+		if value, isExist := template.Template.Labels[applabels.ServiceSelector]; !isExist {
+			template.Template.Labels[applabels.ServiceSelector] = deploy.Name
+		} else if value != deploy.Name {
+			return nil, nil, fmt.Errorf("the pod label %s=%s of deployment %s does not match the deployment name", applabels.ServiceSelector, value, deploy.Name)
+		}
+		app.Spec.Modules = append(app.Spec.Modules, appv1.Module{Name: deploy.Name, Template: template})
+	}
+	for i := range app.Spec.Modules {
+		modules[app.Spec.Modules[i].Name] = &app.Spec.Modules[i]
+	}
+
+	for _, svc := range svcs {
+		module, isExist := modules[svc.Name]
+		if !isExist {
+			warnings = append(warnings, fmt.Sprintf("svc %s has no deployment with the same name, ignored", svc.Name))
+			continue
+		}
+		// 控制器根据容器端口生成svc, 补齐svc中存在但容器未声明的端口
+		containers := module.Template.Template.Spec.Containers
+		for _, port := range svc.Spec.Ports {
+			targetPort := port.TargetPort.IntValue()
+			if targetPort == 0 {
+				targetPort = int(port.Port)
+			}
+			if int(port.Port) != targetPort {
+				warnings = append(warnings, fmt.Sprintf("svc %s port %d will be exposed as %d, the same as the target port", svc.Name, port.Port, targetPort))
+			}
+			if len(containers) == 0 || hasContainerPort(containers, int32(targetPort), port.Protocol) {
+				continue
+			}
+			containers[0].Ports = append(containers[0].Ports, corev1.ContainerPort{ContainerPort: int32(targetPort), Protocol: port.Protocol})
+		}
+		if svc.Spec.Type != "" && svc.Spec.Type != corev1.ServiceTypeClusterIP {
+			warnings = append(warnings, fmt.Sprintf("svc %s will be changed from %s to %s", svc.Name, svc.Spec.Type, corev1.ServiceTypeClusterIP))
+		}
+	}
+	return app, warnings, nil
+}
+
+func hasContainerPort(containers []corev1.Container, port int32, protocol corev1.Protocol) bool {
+	if protocol == "" {
+		protocol = corev1.ProtocolTCP
+	}
+	for _, container := range containers {
+		for _, containerPort := range container.Ports {
+			if containerPort.ContainerPort != port {
+				continue
+			}
+			if containerPort.Protocol == protocol || (containerPort.Protocol == "" && protocol == corev1.ProtocolTCP) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,145 @@
+/**
+ * 功能描述: 验证已有deployment和svc的接管
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+func newLegacyWorkloads(name string) (*appsv1.Deployment, *corev1.Service) {
+	module := newTestModule(name)
+	deploy := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": name}},
+		Spec:       module.Template,
+	}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": name}},
+		Spec: corev1.ServiceSpec{
+			Selector: map[string]string{"name": name},
+			Ports:    []corev1.ServicePort{{Port: 80, TargetPort: intstr.FromInt(80), Protocol: corev1.ProtocolTCP}},
+		},
+	}
+	return deploy, svc
+}
+
+func TestReconcileInstanceSkipsUnmanagedDeployment(t *testing.T) {
+	app := newTestApplication(newTestModule("web"))
+	deploy, svc := newLegacyWorkloads("web")
+	r, recorder := newTestReconciler(t, app, deploy, svc)
+
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	expectEvent(t, drainEvents(recorder), "Warning AdoptionSkipped module web: deployment web already exists")
+
+	found := &appsv1.Deployment{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "web"}, found); err != nil {
+		t.Fatal(err)
+	}
+	if metav1.GetControllerOf(found) != nil || found.Labels[applabels.AppName] != "" {
+		t.Errorf("expected unmanaged deployment to be kept as it is, got %v", found.ObjectMeta)
+	}
+}
+
+func TestReconcileInstanceAdoptsDeployment(t *testing.T) {
+	app := newTestApplication(newTestModule("web"))
+	app.Annotations = map[string]string{applabels.AdoptAnnotation: "true"}
+	deploy, svc := newLegacyWorkloads("web")
+	r, recorder := newTestReconciler(t, app, deploy, svc)
+
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	expectEvent(t, drainEvents(recorder), "Normal ModuleAdopted module web: adopted deployment web")
+
+	found := &appsv1.Deployment{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "web"}, found); err != nil {
+		t.Fatal(err)
+	}
+	if !metav1.IsControlledBy(found, app) {
+		t.Errorf("expected deployment to be controlled by application, got %v", found.OwnerReferences)
+	}
+	if found.Labels[applabels.AppName] != app.Name || found.Labels["app"] != "web" {
+		t.Errorf("expected controller labels to be merged, got %v", found.Labels)
+	}
+	foundSvc := &corev1.Service{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "web"}, foundSvc); err != nil {
+		t.Fatal(err)
+	}
+	if foundSvc.Labels[applabels.AppName] != app.Name {
+		t.Errorf("expected svc labels to be merged, got %v", foundSvc.Labels)
+	}
+
+	// 接管后不再作为孤立的deployment清理
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "web"}, found); err != nil {
+		t.Errorf("expected adopted deployment to be kept: %v", err)
+	}
+}
+
+func TestBuildApplicationFromWorkloads(t *testing.T) {
+	manifest := `apiVersion: v1
+kind: List
+items:
+- apiVersion: apps/v1
+  kind: Deployment
+  metadata:
+    name: ecp
+    namespace: prod
+  spec:
+    selector:
+      matchLabels:
+        app: ecp
+    template:
+      metadata:
+        labels:
+          app: ecp
+      spec:
+        containers:
+        - name: tomcat
+          image: 192.168.31.132/appdeploy/tomcat:7
+- apiVersion: v1
+  kind: Service
+  metadata:
+    name: ecp
+    namespace: prod
+  spec:
+    ports:
+    - port: 8080
+      targetPort: 8080
+`
+	deploys, svcs, err := DecodeWorkloads(strings.NewReader(manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	app, warnings, err := BuildApplicationFromWorkloads("ecp", "", 2, deploys, svcs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("unexpected warnings %v", warnings)
+	}
+	if app.Namespace != "prod" || app.Annotations[applabels.AdoptAnnotation] != "true" || len(app.Spec.Modules) != 1 {
+		t.Fatalf("unexpected application %v", app)
+	}
+	template := app.Spec.Modules[0].Template.Template
+	if template.Labels[applabels.ServiceSelector] != "ecp" {
+		t.Errorf("expected svc selector label on pod template, got %v", template.Labels)
+	}
+	if !hasContainerPort(template.Spec.Containers, 8080, corev1.ProtocolTCP) {
+		t.Errorf("expected svc port as container port, got %v", template.Spec.Containers)
+	}
+}
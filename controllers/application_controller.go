@@ -21,15 +21,24 @@ import (
 	"fmt"
 	"github.com/go-logr/logr"
 	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"golang.org/x/time/rate"
 	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"reflect"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	logf "sigs.k8s.io/controller-runtime/pkg/runtime/log"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"time"
 )
 
 // ApplicationReconciler reconciles a Application object
@@ -38,25 +47,47 @@ type ApplicationReconciler struct {
 	Log      logr.Logger
 	Scheme   *runtime.Scheme
 	Recorder record.EventRecorder
+	// 控制器级别的module默认配置
+	Defaults ModuleDefaults
+	// 是否限制应用只能部署在为其用户授权的namespace中
+	EnforceUserNamespace bool
+	// 演练模式, 只计算并记录需要执行的操作, 不写入集群
+	DryRun bool
+	// 是否根据pod的Ready状态汇总module的健康检查结果
+	AggregateHealth bool
+	// 是否watch ConfigMap和Secret, 开启后缓存全集群的ConfigMap和Secret, 配置变化时立即滚动更新;
+	// 关闭时只在调谐应用时直接读取引用的配置, 配置变化在下一次调谐时生效
+	WatchConfigChanges bool
+	// 应用处于Starting或Progressing时重新调谐的间隔, 为0时只依赖watch事件
+	ProgressRequeueInterval time.Duration
+	// 单次调谐的超时时间, 到期后取消调谐中的所有API调用, 为0时不限制
+	ReconcileTimeout time.Duration
+	// 同时调谐的应用数量, 默认为1
+	MaxConcurrentReconciles int
+	// 调谐失败后的重试限速, 替换controller的限速队列, 为空时使用controller-runtime默认的限速队列
+	RateLimiter workqueue.RateLimiter
+	// 不经过缓存直接读取apiserver, 用于多个worker并发修改的ingress configmap和镜像拉取secret
+	APIReader client.Reader
+
+	// 已记录的等待和演练事件, 用于避免重复记录
+	recorded *recordedEvents
 }
 
 var log = logf.Log.WithName("controller")
 
-var (
-	APPNameLabel    = "app.dsgkinfo.com/appName"
-	ModuleNameLabel = "app.dsgkinfo.com/moduleName"
-	PodType         = "app.dsgkinfo.com/podType"
-	DeploymentType  = "app.dsgkinfo.com/deploymentType"
-	LocalCache      = make(map[string]string)
-)
-
 // +kubebuilder:rbac:groups=app.dsgkinfo.com,resources=applications,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=app.dsgkinfo.com,resources=applications/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=apps,resources=deployments/status,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;update;patch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
 
 func (r *ApplicationReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
-	ctx := context.Background()
+	ctx, cancel := r.reconcileContext()
+	defer cancel()
 	log := r.Log.WithValues("application", req.NamespacedName)
 	log.Info("reconciling...")
 
@@ -64,6 +95,8 @@ func (r *ApplicationReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error)
 	err := r.Get(ctx, req.NamespacedName, &app)
 	if err != nil {
 		if apierrs.IsNotFound(err) {
+			forgetApplicationMetrics(req.NamespacedName)
+			r.recorded.forget(req.NamespacedName)
 			return reconcile.Result{}, nil
 		}
 		log.Error(err, "unable to fetch application")
@@ -71,6 +104,11 @@ func (r *ApplicationReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error)
 	}
 	log.Info("get app successful.", "display name", app.Spec.DisplayName)
 
+	// 演练模式下所有写操作只记录为计划
+	if r.DryRun {
+		r = r.withDryRun(&app)
+	}
+
 	// 判断是否在删除中
 	FinalizerName := "finalizers.app.dsgkinfo.com"
 	if app.ObjectMeta.DeletionTimestamp.IsZero() {
@@ -88,7 +126,7 @@ func (r *ApplicationReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error)
 		if containsString(app.ObjectMeta.Finalizers, FinalizerName) {
 			// 进行pre delete
 			log.Info("the app will be deleted, update the app status to deleting.")
-			r.Recorder.Event(&app, "Normal", "Killing", fmt.Sprintf("Deleting application %s/%s", app.Namespace, app.Spec.DisplayName))
+			r.Recorder.Event(&app, corev1.EventTypeNormal, ReasonApplicationDeleting, fmt.Sprintf("Deleting application %s/%s", app.Namespace, app.Spec.DisplayName))
 			app.Status.Status = "Deleting"
 			err = r.Update(ctx, &app)
 			if err != nil {
@@ -96,51 +134,133 @@ func (r *ApplicationReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error)
 			}
 			log.Info("updated app status successful.", "status", app.Status.Status)
 			log.Info("the app will be deleted, deleting dependence resource.")
-			err = r.deleteDependenceResource(&app)
+			err = r.deleteDependenceResource(ctx, &app)
 			if err != nil {
 				log.Error(err, "failed to delete dependence resource.", "namespace", app.Namespace, "applicationName", app.Name)
-				return ctrl.Result{}, err
+				return ctrl.Result{}, reconcileError(ctx, "delete", err)
 			}
 			log.Info("successful delete dependence resource.")
-			r.Recorder.Event(&app, "Normal", "SuccessfulDelete", fmt.Sprintf("Deleted application %s/%s", app.Namespace, app.Spec.DisplayName))
+			r.Recorder.Event(&app, corev1.EventTypeNormal, ReasonApplicationDeleted, fmt.Sprintf("Deleted application %s/%s", app.Namespace, app.Spec.DisplayName))
 
 			// 删除成功,清空finalizers
 			app.ObjectMeta.Finalizers = removeString(app.ObjectMeta.Finalizers, FinalizerName)
 			err = r.Update(ctx, &app)
+			if err == nil {
+				forgetApplicationMetrics(req.NamespacedName)
+			}
 			return ctrl.Result{}, err
 		}
 	}
 
+	// 检查应用所在的namespace是否已授权给应用的用户
+	allowed, err := r.reconcileUserNamespace(ctx, &app)
+	if err != nil {
+		log.Error(err, "failed to check user namespace.", "namespace", app.Namespace, "applicationName", app.Name)
+		return ctrl.Result{}, err
+	}
+	if !allowed {
+		return ctrl.Result{}, nil
+	}
+
 	// 对status进行调谐
 	log.Info("reconcile status...", "display name", app.Spec.DisplayName)
-	if err := r.reconcileStatus(&app); err != nil {
+	if err := r.reconcileStatus(ctx, &app); err != nil {
 		log.Error(err, "failed to reconcile status.", "namespace", app.Namespace, "applicationName", app.Namespace)
-		return ctrl.Result{}, err
+		return ctrl.Result{}, reconcileError(ctx, "status", err)
+	}
+
+	// 对镜像拉取secret进行调谐
+	log.Info("reconcile image pull secrets...", "display name", app.Spec.DisplayName)
+	if err := r.reconcileImagePullSecrets(ctx, &app); err != nil {
+		log.Error(err, "failed to reconcile image pull secrets.", "namespace", app.Namespace, "applicationName", app.Name)
+		return ctrl.Result{}, reconcileError(ctx, "secret", err)
 	}
 
 	// 进行Module实例调谐
 	log.Info("reconcile instance...", "display name", app.Spec.DisplayName)
-	if err := r.reconcileInstance(&app); err != nil {
+	if err := r.reconcileInstance(ctx, &app); err != nil {
 		log.Error(err, "failed to reconcile instance.", "namespace", app.Namespace, "applicationName", app.Name)
-		return ctrl.Result{}, err
+		return ctrl.Result{}, reconcileError(ctx, "instance", err)
 	}
 
 	// 对svc进行调谐
 	log.Info("reconcile svc...", "display name", app.Spec.DisplayName)
-	if err := r.reconcileSvc(&app); err != nil {
+	if err := r.reconcileSvc(ctx, &app); err != nil {
 		log.Error(err, "failed to reconcile svc.", "namespace", app.Namespace, "applicationName", app.Namespace)
-		return ctrl.Result{}, err
+		return ctrl.Result{}, reconcileError(ctx, "svc", err)
+	}
+
+	// 对network policy进行调谐
+	log.Info("reconcile network policy...", "display name", app.Spec.DisplayName)
+	if err := r.reconcileNetworkPolicy(ctx, &app); err != nil {
+		log.Error(err, "failed to reconcile network policy.", "namespace", app.Namespace, "applicationName", app.Name)
+		return ctrl.Result{}, reconcileError(ctx, "networkpolicy", err)
 	}
 
 	// 对proxy进行调谐
 	log.Info("reconcile proxy...", "display name", app.Spec.DisplayName)
-	if err := r.reconcileProxy(&app); err != nil {
+	if err := r.reconcileProxy(ctx, &app); err != nil {
 		log.Error(err, "failed to reconcile proxy.", "namespace", app.Namespace, "applicationName", app.Name)
-		return ctrl.Result{}, err
+		return ctrl.Result{}, reconcileError(ctx, "proxy", err)
 	}
 
 	log.Info("reconcile all done.", "display name", app.Spec.DisplayName)
-	return ctrl.Result{}, nil
+	// 存在延迟删除的module或应用仍在启动、更新时, 到期后重新调谐
+	return ctrl.Result{RequeueAfter: r.requeueAfter(&app)}, nil
+}
+
+// 不经过缓存读取资源的reader, 用于在并发修改时缓存可能落后的ingress configmap, 以及只在少数namespace中读取的secret等资源,
+// 避免为其启动全集群的informer. 未设置APIReader时使用client
+func (r *ApplicationReconciler) apiReader() client.Reader {
+	if r.APIReader != nil {
+		return r.APIReader
+	}
+	return r.Client
+}
+
+// 按照限速设置生成重试限速器: 每个应用按失败次数指数退避, 所有应用的重试总体按qps和burst限速
+func NewRateLimiter(baseDelay, maxDelay time.Duration, qps float64, burst int) workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(baseDelay, maxDelay),
+		&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(qps), burst)},
+	)
+}
+
+// controller-runtime v0.4.0(见go.mod)没有提供设置限速队列的选项, 通过内部controller导出的MakeQueue字段替换启动时创建的队列,
+// 调谐返回错误时由队列按该限速器退避重试. 该字段不是公开的API, 升级controller-runtime时需要确认字段仍然存在,
+// 新版本提供controller.Options.RateLimiter后应改为使用该选项. 字段不存在或类型不一致时返回错误, 由调用方回退到默认队列
+func setRateLimiter(c controller.Controller, name string, limiter workqueue.RateLimiter) error {
+	value := reflect.ValueOf(c)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("unable to set the rate limiter of controller %s", name)
+	}
+	field := value.Elem().FieldByName("MakeQueue")
+	makeQueue := func() workqueue.RateLimitingInterface {
+		return workqueue.NewNamedRateLimitingQueue(limiter, name)
+	}
+	if !field.IsValid() || !field.CanSet() || field.Type() != reflect.TypeOf(makeQueue) {
+		return fmt.Errorf("unable to set the rate limiter of controller %s", name)
+	}
+	field.Set(reflect.ValueOf(makeQueue))
+	return nil
+}
+
+// 每次调谐使用独立的context, 设置了超时时间时到期后取消所有API调用, 避免卡住的请求长期占用worker
+func (r *ApplicationReconciler) reconcileContext() (context.Context, context.CancelFunc) {
+	if r.ReconcileTimeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), r.ReconcileTimeout)
+}
+
+// 记录调谐失败的阶段. 超时的错误同样返回给controller-runtime, 按限速队列的退避时间重新调谐
+func reconcileError(ctx context.Context, phase string, err error) error {
+	reconcileFailuresTotal.WithLabelValues(phase).Inc()
+	if ctx.Err() != context.DeadlineExceeded {
+		return err
+	}
+	reconcileTimeoutsTotal.WithLabelValues(phase).Inc()
+	return fmt.Errorf("reconcile %s timed out, will retry with backoff: %w", phase, err)
 }
 
 var (
@@ -149,6 +269,7 @@ var (
 )
 
 func (r *ApplicationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.recorded = newRecordedEvents()
 	// 设置查询索引
 	if err := mgr.GetFieldIndexer().IndexField(&v1.Deployment{}, deploymentOwnKey, func(object runtime.Object) []string {
 		deploy := object.(*v1.Deployment)
@@ -164,9 +285,30 @@ func (r *ApplicationReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	}); err != nil {
 		return err
 	}
+	if err := mgr.GetFieldIndexer().IndexField(&appv1.Application{}, appConfigRefKey, indexApplicationConfigRefs); err != nil {
+		return err
+	}
 
-	return ctrl.NewControllerManagedBy(mgr).
+	builder := ctrl.NewControllerManagedBy(mgr).
 		For(&appv1.Application{}).
 		Owns(&v1.Deployment{}).
-		Complete(r)
+		Owns(&networkingv1.NetworkPolicy{})
+	if r.WatchConfigChanges {
+		builder = builder.
+			Watches(&source.Kind{Type: &corev1.ConfigMap{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: r.applicationsForConfig(configMapKind)}).
+			Watches(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestsFromMapFunc{ToRequests: r.applicationsForConfig(secretKind)})
+	}
+	c, err := builder.
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
+		Build(r)
+	if err != nil {
+		return err
+	}
+	if r.RateLimiter == nil {
+		return nil
+	}
+	if err := setRateLimiter(c, "application", r.RateLimiter); err != nil {
+		log.Error(err, "failed to set the rate limiter, falling back to the default rate limiter of controller-runtime.")
+	}
+	return nil
 }
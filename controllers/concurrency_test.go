@@ -0,0 +1,210 @@
+/**
+ * 功能描述: 验证多个worker并发调谐时的proxy规则和重试限速, 并对大量应用的调谐进行基准测试
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// fake client不校验resourceVersion, 这里像apiserver一样对ConfigMap的并发修改返回冲突
+type conflictCheckingClient struct {
+	client.Client
+	lock sync.Mutex
+}
+
+func (c *conflictCheckingClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	configMap, ok := obj.(*corev1.ConfigMap)
+	if !ok {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	current := &corev1.ConfigMap{}
+	if err := c.Client.Get(ctx, types.NamespacedName{Namespace: configMap.Namespace, Name: configMap.Name}, current); err != nil {
+		return err
+	}
+	if current.ResourceVersion != configMap.ResourceVersion {
+		return apierrs.NewConflict(corev1.Resource("configmaps"), configMap.Name, fmt.Errorf("the object has been modified"))
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+// 生成number个位于不同namespace的应用, 每个应用占用一个不同的tcp端口
+func newConcurrentTestReconciler(t testing.TB, number int) (*ApplicationReconciler, []ctrl.Request) {
+	objs := newTestProxyConfigMaps(nil)
+	requests := make([]ctrl.Request, 0, number)
+	for i := 0; i < number; i++ {
+		app := newTestApplication(newTestModule("web", appv1.Proxy{Protocol: "tcp", Port: 80, TargetPort: int32(10000 + i)}))
+		app.Name = fmt.Sprintf("app-%d", i)
+		app.Namespace = fmt.Sprintf("ns-%d", i)
+		app.UID = types.UID(app.Name + "-uid")
+		objs = append(objs, app)
+		requests = append(requests, ctrl.Request{NamespacedName: types.NamespacedName{Namespace: app.Namespace, Name: app.Name}})
+	}
+	r, _ := newTestReconciler(t, objs...)
+	// 丢弃事件, 避免大量事件阻塞fake recorder
+	r.Recorder = &record.FakeRecorder{}
+	r.Client = &conflictCheckingClient{Client: r.Client}
+	return r, requests
+}
+
+// 使用workers个goroutine并发调谐所有应用
+func reconcileConcurrently(t testing.TB, r *ApplicationReconciler, requests []ctrl.Request, workers int) {
+	queue := make(chan ctrl.Request, len(requests))
+	for _, req := range requests {
+		queue <- req
+	}
+	close(queue)
+	errs := make(chan error, len(requests))
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for req := range queue {
+				if _, err := r.Reconcile(req); err != nil {
+					errs <- fmt.Errorf("reconcile %s: %v", req.NamespacedName, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrentReconcileKeepsAllProxyRules(t *testing.T) {
+	r, requests := newConcurrentTestReconciler(t, 50)
+	reconcileConcurrently(t, r, requests, 8)
+
+	configMap := getTCPConfigMap(t, r)
+	if len(configMap.Data) != len(requests) || len(getProxyOwners(configMap)) != len(requests) {
+		t.Errorf("expected %d proxy rules, got %d rules and %d owners", len(requests), len(configMap.Data), len(getProxyOwners(configMap)))
+	}
+	for i, req := range requests {
+		if value := configMap.Data[fmt.Sprintf("%d", 10000+i)]; value != req.Namespace+"/web:80" {
+			t.Errorf("unexpected proxy rule of %s: %q", req.NamespacedName, value)
+		}
+	}
+}
+
+func TestRateLimiterRequeuesFailedReconcile(t *testing.T) {
+	limiter := NewRateLimiter(10*time.Millisecond, 40*time.Millisecond, 100, 100)
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "wsgw"}}
+	delays := []time.Duration{limiter.When(req), limiter.When(req), limiter.When(req), limiter.When(req)}
+	if delays[0] != 10*time.Millisecond || delays[1] != 20*time.Millisecond || delays[3] != 40*time.Millisecond {
+		t.Errorf("unexpected retry delays %v", delays)
+	}
+	limiter.Forget(req)
+	if delay := limiter.When(req); delay != 10*time.Millisecond {
+		t.Errorf("expected delay to be reset after success, got %v", delay)
+	}
+
+	// 应用所在namespace不存在时, 开启用户namespace限制的调谐会失败, 错误返回给controller-runtime由限速队列重试
+	r, _ := newTestReconciler(t, newTestApplication())
+	r.EnforceUserNamespace = true
+	r.RateLimiter = NewRateLimiter(time.Second, time.Minute, 100, 100)
+	result, err := r.Reconcile(req)
+	if err == nil || result.RequeueAfter != 0 {
+		t.Errorf("expected failed reconcile to return the error, got %v %v", result, err)
+	}
+}
+
+// 记录重试次数的限速器
+type countingRateLimiter struct {
+	workqueue.RateLimiter
+	retries int
+}
+
+func (l *countingRateLimiter) When(item interface{}) time.Duration {
+	l.retries += 1
+	return l.RateLimiter.When(item)
+}
+
+func TestSetRateLimiterReplacesControllerQueue(t *testing.T) {
+	mgr, err := ctrl.NewManager(&rest.Config{Host: "http://127.0.0.1:1"}, ctrl.Options{
+		MetricsBindAddress: "0",
+		MapperProvider: func(*rest.Config) (meta.RESTMapper, error) {
+			return meta.NewDefaultRESTMapper(nil), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _ := newTestReconciler(t)
+	c, err := controller.New("application", mgr, controller.Options{Reconciler: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := &countingRateLimiter{RateLimiter: NewRateLimiter(time.Millisecond, time.Second, 100, 100)}
+	if err := setRateLimiter(c, "application", limiter); err != nil {
+		t.Fatalf("set rate limiter: %v", err)
+	}
+
+	makeQueue := reflect.ValueOf(c).Elem().FieldByName("MakeQueue").Interface().(func() workqueue.RateLimitingInterface)
+	queue := makeQueue()
+	defer queue.ShutDown()
+	queue.AddRateLimited(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "wsgw"}})
+	if limiter.retries != 1 {
+		t.Errorf("expected the controller queue to use the rate limiter, got %d retries", limiter.retries)
+	}
+}
+
+// 没有MakeQueue字段的controller, 模拟controller-runtime升级后内部结构发生变化
+type queuelessController struct {
+	reconcile.Reconciler
+}
+
+func (c *queuelessController) Watch(src source.Source, eventhandler handler.EventHandler, predicates ...predicate.Predicate) error {
+	return nil
+}
+
+func (c *queuelessController) Start(stop <-chan struct{}) error {
+	return nil
+}
+
+func TestSetRateLimiterFailsWithoutMakeQueue(t *testing.T) {
+	r, _ := newTestReconciler(t)
+	if err := setRateLimiter(&queuelessController{Reconciler: r}, "application", workqueue.DefaultControllerRateLimiter()); err == nil {
+		t.Errorf("expected an error for a controller without MakeQueue")
+	}
+}
+
+func BenchmarkReconcileApplications(b *testing.B) {
+	for _, workers := range []int{1, 8} {
+		b.Run(fmt.Sprintf("workers-%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				r, requests := newConcurrentTestReconciler(b, 200)
+				b.StartTimer()
+				reconcileConcurrently(b, r, requests, workers)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+/**
+ * 功能描述: 计算module引用的ConfigMap和Secret的校验和, 配置内容变化时触发滚动更新
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sort"
+	"strings"
+)
+
+var (
+	appConfigRefKey = ".spec.modules.configRefs"
+	configMapKind   = "configmap"
+	secretKind      = "secret"
+)
+
+// 生成配置的索引值, 格式为 类型/名称
+func configRef(kind, name string) string {
+	return kind + "/" + name
+}
+
+// 获取pod中通过环境变量和卷引用的ConfigMap和Secret, 返回去重排序后的索引值
+func referencedConfigs(podSpec *corev1.PodSpec) []string {
+	refs := make(map[string]bool)
+	containers := append(append([]corev1.Container{}, podSpec.InitContainers...), podSpec.Containers...)
+	for _, container := range containers {
+		for _, env := range container.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if ref := env.ValueFrom.ConfigMapKeyRef; ref != nil {
+				refs[configRef(configMapKind, ref.Name)] = true
+			}
+			if ref := env.ValueFrom.SecretKeyRef; ref != nil {
+				refs[configRef(secretKind, ref.Name)] = true
+			}
+		}
+		for _, envFrom := range container.EnvFrom {
+			if ref := envFrom.ConfigMapRef; ref != nil {
+				refs[configRef(configMapKind, ref.Name)] = true
+			}
+			if ref := envFrom.SecretRef; ref != nil {
+				refs[configRef(secretKind, ref.Name)] = true
+			}
+		}
+	}
+	for _, volume := range podSpec.Volumes {
+		if volume.ConfigMap != nil {
+			refs[configRef(configMapKind, volume.ConfigMap.Name)] = true
+		}
+		if volume.Secret != nil {
+			refs[configRef(secretKind, volume.Secret.SecretName)] = true
+		}
+		if volume.Projected == nil {
+			continue
+		}
+		for _, source := range volume.Projected.Sources {
+			if source.ConfigMap != nil {
+				refs[configRef(configMapKind, source.ConfigMap.Name)] = true
+			}
+			if source.Secret != nil {
+				refs[configRef(secretKind, source.Secret.Name)] = true
+			}
+		}
+	}
+	result := make([]string, 0, len(refs))
+	for ref := range refs {
+		result = append(result, ref)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// 按开启了配置变更滚动更新的module所引用的配置索引应用, 用于配置变化时查找需要调谐的应用
+func indexApplicationConfigRefs(object runtime.Object) []string {
+	app := object.(*appv1.Application)
+	refs := make([]string, 0)
+	for i := range app.Spec.Modules {
+		module := &app.Spec.Modules[i]
+		if module.RolloutOnConfigChange {
+			refs = append(refs, referencedConfigs(&module.Template.Template.Spec)...)
+		}
+	}
+	return refs
+}
+
+// 计算pod引用的所有配置内容的校验和, 不存在的配置也参与计算, 创建后同样触发滚动更新
+func (r *ApplicationReconciler) configChecksum(ctx context.Context, namespace string, podSpec *corev1.PodSpec) (string, error) {
+	hash := sha256.New()
+	for _, ref := range referencedConfigs(podSpec) {
+		fmt.Fprintf(hash, "%s\n", ref)
+		data, err := r.getConfigData(ctx, namespace, ref)
+		if err != nil {
+			return "", err
+		}
+		keys := make([]string, 0, len(data))
+		for key := range data {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(hash, "%s=%x\n", key, data[key])
+		}
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// 读取module引用配置的reader, 只有watch配置时才从缓存读取, 否则直接读取apiserver, 避免启动全集群的informer
+func (r *ApplicationReconciler) configReader() client.Reader {
+	if r.WatchConfigChanges {
+		return r.Client
+	}
+	return r.apiReader()
+}
+
+// 读取配置的内容, 配置不存在时返回nil
+func (r *ApplicationReconciler) getConfigData(ctx context.Context, namespace, ref string) (map[string][]byte, error) {
+	parts := strings.SplitN(ref, "/", 2)
+	nsn := types.NamespacedName{Namespace: namespace, Name: parts[1]}
+	data := make(map[string][]byte)
+	var err error
+	switch parts[0] {
+	case configMapKind:
+		configMap := &corev1.ConfigMap{}
+		if err = r.configReader().Get(ctx, nsn, configMap); err == nil {
+			for key, value := range configMap.Data {
+				data[key] = []byte(value)
+			}
+			for key, value := range configMap.BinaryData {
+				data[key] = value
+			}
+		}
+	case secretKind:
+		secret := &corev1.Secret{}
+		if err = r.configReader().Get(ctx, nsn, secret); err == nil {
+			data = secret.Data
+		}
+	default:
+		return nil, fmt.Errorf("unknown config reference %s", ref)
+	}
+	if err != nil && apierrs.IsNotFound(err) {
+		return nil, nil
+	} else if err != nil {
+		log.Error(err, "failed to get config referenced by module.", "namespace", namespace, "ref", ref)
+		return nil, err
+	}
+	return data, nil
+}
+
+// 将引用配置的校验和写入pod模板的注解, 校验和变化时deployment会滚动更新
+// 控制器没有watch配置时, 配置变化只在下一次调谐时生效, 记录一次警告事件提醒开启--watch-config-changes
+func (r *ApplicationReconciler) setConfigChecksum(ctx context.Context, app *appv1.Application, module *appv1.Module, template *corev1.PodTemplateSpec) error {
+	if !module.RolloutOnConfigChange {
+		return nil
+	}
+	if !r.WatchConfigChanges && r.recorded.changed(fmt.Sprintf("%s/%s/confignotwatched/%s", app.Namespace, app.Name, module.Name), "warned") {
+		r.recordModuleEvent(app, module.Name, corev1.EventTypeWarning, ReasonConfigNotWatched,
+			"rolloutOnConfigChange is set but the controller does not watch ConfigMaps and Secrets, config changes are rolled out on the next reconcile. Start the controller with --watch-config-changes to roll out immediately")
+	}
+	checksum, err := r.configChecksum(ctx, app.Namespace, &template.Spec)
+	if err != nil {
+		return err
+	}
+	if template.Annotations == nil {
+		template.Annotations = make(map[string]string)
+	}
+	template.Annotations[applabels.ConfigChecksumAnnotation] = checksum
+	return nil
+}
+
+// ConfigMap或Secret变化时, 找出引用该配置并开启了滚动更新的应用
+func (r *ApplicationReconciler) applicationsForConfig(kind string) handler.ToRequestsFunc {
+	return func(object handler.MapObject) []reconcile.Request {
+		ref := configRef(kind, object.Meta.GetName())
+		appList := &appv1.ApplicationList{}
+		if err := r.List(context.TODO(), appList, client.InNamespace(object.Meta.GetNamespace()), client.MatchingField(appConfigRefKey, ref)); err != nil {
+			log.Error(err, "failed to list applications by config reference.", "namespace", object.Meta.GetNamespace(), "ref", ref)
+			return nil
+		}
+		requests := make([]reconcile.Request, 0)
+		for i := range appList.Items {
+			app := &appList.Items[i]
+			requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: app.Namespace, Name: app.Name}})
+		}
+		return requests
+	}
+}
@@ -0,0 +1,101 @@
+/**
+ * 功能描述: 验证module引用的配置变化时触发滚动更新
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+func TestConfigChangeTriggersRollout(t *testing.T) {
+	web := newTestModule("web")
+	web.RolloutOnConfigChange = true
+	web.Template.Template.Spec.Containers[0].EnvFrom = []corev1.EnvFromSource{{
+		ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "web-config"}},
+	}}
+	api := newTestModule("api")
+	api.Template.Template.Spec.Containers[0].EnvFrom = web.Template.Template.Spec.Containers[0].EnvFrom
+	app := newTestApplication(web, api)
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web-config"},
+		Data:       map[string]string{"LOG_LEVEL": "info"},
+	}
+	r, recorder := newTestReconciler(t, append(newTestProxyConfigMaps(nil), app, configMap)...)
+
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	deploy, err := getTestDeployment(r, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksum := deploy.Spec.Template.Annotations[applabels.ConfigChecksumAnnotation]
+	if checksum == "" {
+		t.Fatalf("expected config checksum on pod template, got %v", deploy.Spec.Template.Annotations)
+	}
+	if deploy, err := getTestDeployment(r, "api"); err != nil || deploy.Spec.Template.Annotations[applabels.ConfigChecksumAnnotation] != "" {
+		t.Errorf("expected no config checksum for module without opt-in, got %v %v", deploy.Spec.Template.Annotations, err)
+	}
+
+	// 应用按开启了滚动更新的module引用的配置建立索引, 配置变化时通过索引查找应用
+	if refs := indexApplicationConfigRefs(app); len(refs) != 1 || refs[0] != configRef(configMapKind, "web-config") {
+		t.Errorf("expected the config reference of opted-in module to be indexed, got %v", refs)
+	}
+	if refs := indexApplicationConfigRefs(newTestApplication(api)); len(refs) != 0 {
+		t.Errorf("expected no config reference indexed without opt-in, got %v", refs)
+	}
+	requests := r.applicationsForConfig(configMapKind)(handler.MapObject{Meta: configMap, Object: configMap})
+	if len(requests) != 1 || requests[0].Name != app.Name {
+		t.Errorf("expected config change to enqueue the application, got %v", requests)
+	}
+
+	configMap.Data["LOG_LEVEL"] = "debug"
+	if err := r.Update(context.TODO(), configMap); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	deploy, err = getTestDeployment(r, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value := deploy.Spec.Template.Annotations[applabels.ConfigChecksumAnnotation]; value == "" || value == checksum {
+		t.Errorf("expected config checksum to change, got %q", value)
+	}
+
+	// 没有watch配置时只提醒一次
+	warnings := 0
+	for _, event := range drainEvents(recorder) {
+		if strings.Contains(event, ReasonConfigNotWatched) {
+			warnings += 1
+		}
+	}
+	if warnings != 1 {
+		t.Errorf("expected one %s event, got %d", ReasonConfigNotWatched, warnings)
+	}
+}
+
+func TestConfigChecksumWithWatchDoesNotWarn(t *testing.T) {
+	web := newTestModule("web")
+	web.RolloutOnConfigChange = true
+	app := newTestApplication(web)
+	r, recorder := newTestReconciler(t, append(newTestProxyConfigMaps(nil), app)...)
+	r.WatchConfigChanges = true
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	for _, event := range drainEvents(recorder) {
+		if strings.Contains(event, ReasonConfigNotWatched) {
+			t.Errorf("expected no warning when configs are watched, got %s", event)
+		}
+	}
+}
@@ -8,27 +8,40 @@ package controllers
 import (
 	"context"
 	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
+	v1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 )
 
-func (r *ApplicationReconciler) deleteDependenceResource(app *appv1.Application) error {
+func (r *ApplicationReconciler) deleteDependenceResource(ctx context.Context, app *appv1.Application) error {
+	// 保留生成的资源, 只解除与应用的关联
+	if app.Spec.DeletionPolicy == appv1.DeletionPolicyOrphan || app.Spec.DeletionPolicy == appv1.DeletionPolicyRetain {
+		return r.releaseDependenceResource(ctx, app)
+	}
+
 	for i := range app.Spec.Modules {
 		module := &app.Spec.Modules[i]
 		// 删除module对应的svc
 		svc := &corev1.Service{}
-		err := r.Get(context.TODO(), types.NamespacedName{Name: module.Name, Namespace: app.Namespace}, svc)
+		name := app.ModuleResourceName(module.Name)
+		err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: app.Namespace}, svc)
 		if err == nil {
-			err = r.Delete(context.TODO(), svc)
+			err = r.Delete(ctx, svc)
 			if err != nil {
-				log.Error(err, "failed to delete the isolated svc.", "namespace", app.Namespace, "name", module.Name)
+				log.Error(err, "failed to delete the isolated svc.", "namespace", app.Namespace, "name", name)
 				return err
 			}
-			log.Info("deleted the isolated svc.", "namespace", app.Namespace, "name", module.Name)
+			log.Info("deleted the isolated svc.", "namespace", app.Namespace, "name", name)
 		}
 
 		// 删除module中定义的proxy规则
-		err = r.cleanUpProxy(types.NamespacedName{Namespace: app.Namespace, Name: module.Name})
+		err = r.cleanUpProxy(ctx, app, module.Name)
 		if err != nil {
 			log.Error(err, "failed to clean ingress config map.", "namespace", app.Namespace, "name", module.Name)
 			return err
@@ -37,3 +50,102 @@ func (r *ApplicationReconciler) deleteDependenceResource(app *appv1.Application)
 	}
 	return nil
 }
+
+// 解除生成的资源与应用的关联, 去掉指向应用的owner reference和控制器添加的标签, 避免被垃圾回收或被同名应用当作孤立资源清理.
+// Orphan时删除proxy规则, NetworkPolicy随应用回收; Retain时保留proxy规则和NetworkPolicy, 只删除其归属记录
+func (r *ApplicationReconciler) releaseDependenceResource(ctx context.Context, app *appv1.Application) error {
+	retain := app.Spec.DeletionPolicy == appv1.DeletionPolicyRetain
+	for i := range app.Spec.Modules {
+		module := &app.Spec.Modules[i]
+		nsn := types.NamespacedName{Name: app.ModuleResourceName(module.Name), Namespace: app.Namespace}
+
+		// 只处理由当前应用控制的deployment, svc没有owner reference, 跟随deployment处理
+		deploy := &v1.Deployment{}
+		err := r.Get(ctx, nsn, deploy)
+		if err != nil && !apierrs.IsNotFound(err) {
+			log.Error(err, "failed to get deployment to release.", "namespace", app.Namespace, "name", nsn.Name)
+			return err
+		}
+		if err == nil && metav1.IsControlledBy(deploy, app) {
+			objects := []runtime.Object{deploy}
+			svc := &corev1.Service{}
+			if err := r.Get(ctx, nsn, svc); err == nil {
+				objects = append(objects, svc)
+			} else if !apierrs.IsNotFound(err) {
+				log.Error(err, "failed to get svc to release.", "namespace", app.Namespace, "name", nsn.Name)
+				return err
+			}
+			policy := &networkingv1.NetworkPolicy{}
+			if err := r.Get(ctx, nsn, policy); err == nil && retain {
+				objects = append(objects, policy)
+			} else if err != nil && !apierrs.IsNotFound(err) {
+				log.Error(err, "failed to get network policy to release.", "namespace", app.Namespace, "name", nsn.Name)
+				return err
+			}
+			for _, object := range objects {
+				if err := r.releaseObject(ctx, app, object); err != nil {
+					return err
+				}
+			}
+			r.recordModuleEvent(app, module.Name, corev1.EventTypeNormal, ReasonModuleReleased, "released deployment %s by deletion policy %s", deploy.Name, app.Spec.DeletionPolicy)
+		}
+
+		if retain {
+			err = r.releaseProxy(ctx, app, module.Name)
+		} else {
+			err = r.cleanUpProxy(ctx, app, module.Name)
+		}
+		if err != nil {
+			log.Error(err, "failed to release ingress config map.", "namespace", app.Namespace, "name", module.Name)
+			return err
+		}
+	}
+
+	// pod仍然引用复制的镜像拉取secret, 一并解除关联
+	ownerRef := newAppOwnerReference(app)
+	for _, ref := range imagePullSecretsFor(app, r.Defaults.ImagePullSecret) {
+		secret := &corev1.Secret{}
+		err := r.apiReader().Get(ctx, types.NamespacedName{Namespace: app.Namespace, Name: ref.Name}, secret)
+		if err != nil && apierrs.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			log.Error(err, "failed to get image pull secret to release.", "namespace", app.Namespace, "name", ref.Name)
+			return err
+		}
+		if !hasOwnerReference(secret.OwnerReferences, ownerRef) {
+			continue
+		}
+		if err := r.releaseObject(ctx, app, secret); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 去掉资源上指向应用的owner reference和控制器添加的标签. pod模板中的标签不做修改, 避免触发滚动更新
+func (r *ApplicationReconciler) releaseObject(ctx context.Context, app *appv1.Application, object runtime.Object) error {
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		return err
+	}
+	refs := make([]metav1.OwnerReference, 0)
+	for _, ref := range accessor.GetOwnerReferences() {
+		if ref.UID != app.UID {
+			refs = append(refs, ref)
+		}
+	}
+	accessor.SetOwnerReferences(refs)
+	labels := accessor.GetLabels()
+	for _, key := range managedLabelKeys {
+		delete(labels, key)
+	}
+	accessor.SetLabels(labels)
+	annotations := accessor.GetAnnotations()
+	delete(annotations, applabels.DisplayNameAnnotation)
+	accessor.SetAnnotations(annotations)
+	if err := r.Update(ctx, object); err != nil {
+		log.Error(err, "failed to release resource from application.", "namespace", accessor.GetNamespace(), "name", accessor.GetName())
+		return err
+	}
+	return nil
+}
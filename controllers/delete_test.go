@@ -0,0 +1,95 @@
+/**
+ * 功能描述: 验证不同删除策略下对生成资源的处理
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newDeletingTestReconciler(t *testing.T, deletionPolicy string) (*ApplicationReconciler, *appv1.Application) {
+	app := newTestApplication(newTestModule("web", appv1.Proxy{Protocol: "tcp", Port: 80, TargetPort: 9098}))
+	app.Spec.DeletionPolicy = deletionPolicy
+	objs := append(newTestProxyConfigMaps(nil), app)
+	r, _ := newTestReconciler(t, objs...)
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	if err := r.reconcileSvc(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile svc: %v", err)
+	}
+	if err := r.reconcileProxy(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile proxy: %v", err)
+	}
+	if err := r.deleteDependenceResource(context.TODO(), app); err != nil {
+		t.Fatalf("delete dependence resource: %v", err)
+	}
+	return r, app
+}
+
+func expectReleased(t *testing.T, r *ApplicationReconciler) {
+	t.Helper()
+	nsn := types.NamespacedName{Namespace: "default", Name: "web"}
+	deploy := &appsv1.Deployment{}
+	if err := r.Get(context.TODO(), nsn, deploy); err != nil {
+		t.Fatal(err)
+	}
+	if len(deploy.OwnerReferences) != 0 || deploy.Labels[applabels.AppName] != "" {
+		t.Errorf("expected deployment to be released, got %v", deploy.ObjectMeta)
+	}
+	svc := &corev1.Service{}
+	if err := r.Get(context.TODO(), nsn, svc); err != nil {
+		t.Fatalf("expected svc to be kept: %v", err)
+	}
+	if svc.Labels[applabels.AppName] != "" {
+		t.Errorf("expected svc labels to be removed, got %v", svc.Labels)
+	}
+}
+
+func getTCPConfigMap(t *testing.T, r *ApplicationReconciler) *corev1.ConfigMap {
+	t.Helper()
+	configMap := &corev1.ConfigMap{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: KubeSystemNamespace, Name: IngressTCPConfigMap}, configMap); err != nil {
+		t.Fatal(err)
+	}
+	return configMap
+}
+
+func TestDeleteDependenceResourceOrphan(t *testing.T) {
+	r, _ := newDeletingTestReconciler(t, appv1.DeletionPolicyOrphan)
+	expectReleased(t, r)
+	if configMap := getTCPConfigMap(t, r); len(configMap.Data) != 0 {
+		t.Errorf("expected proxy rules to be removed, got %v", configMap.Data)
+	}
+}
+
+func TestDeleteDependenceResourceRetain(t *testing.T) {
+	r, _ := newDeletingTestReconciler(t, appv1.DeletionPolicyRetain)
+	expectReleased(t, r)
+	configMap := getTCPConfigMap(t, r)
+	if configMap.Data["9098"] != "default/web:80" {
+		t.Errorf("expected proxy rule to be kept, got %v", configMap.Data)
+	}
+	if _, isExist := configMap.Annotations[applabels.ProxyOwnersAnnotation]; isExist {
+		t.Errorf("expected proxy owners to be removed, got %v", configMap.Annotations)
+	}
+}
+
+func TestDeleteDependenceResourceDelete(t *testing.T) {
+	r, _ := newDeletingTestReconciler(t, "")
+	svc := &corev1.Service{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "web"}, svc); err == nil {
+		t.Errorf("expected svc to be deleted")
+	}
+	if configMap := getTCPConfigMap(t, r); len(configMap.Data) != 0 {
+		t.Errorf("expected proxy rules to be removed, got %v", configMap.Data)
+	}
+}
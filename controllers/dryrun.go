@@ -0,0 +1,129 @@
+/**
+ * 功能描述: 演练模式, 计算调谐需要执行的操作但不写入集群
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	"fmt"
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// 演练模式下使用的client, 读操作正常执行, 写操作只记录日志和Planned事件
+type dryRunClient struct {
+	client.Client
+	app      *appv1.Application
+	scheme   *runtime.Scheme
+	recorder record.EventRecorder
+	recorded *recordedEvents
+}
+
+// 丢弃所有事件的recorder
+type discardRecorder struct{}
+
+func (discardRecorder) Event(object runtime.Object, eventtype, reason, message string) {}
+
+func (discardRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+}
+
+func (discardRecorder) PastEventf(object runtime.Object, timestamp metav1.Time, eventtype, reason, messageFmt string, args ...interface{}) {
+}
+
+func (discardRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+}
+
+// 返回一个只记录计划操作的reconciler副本, 用于单次调谐
+func (r *ApplicationReconciler) withDryRun(app *appv1.Application) *ApplicationReconciler {
+	planner := *r
+	planner.Client = &dryRunClient{
+		Client:   r.Client,
+		app:      app,
+		scheme:   r.Scheme,
+		recorder: r.Recorder,
+		recorded: r.recorded,
+	}
+	// 操作没有真正执行, 丢弃调谐过程中记录的其他事件
+	planner.Recorder = discardRecorder{}
+	return &planner
+}
+
+func (c *dryRunClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	c.plan("create", obj, "")
+	return nil
+}
+
+func (c *dryRunClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	c.plan("delete", obj, "")
+	return nil
+}
+
+func (c *dryRunClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	c.plan("update", obj, "")
+	return nil
+}
+
+func (c *dryRunClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	c.plan("patch", obj, string(data))
+	return nil
+}
+
+func (c *dryRunClient) DeleteAllOf(ctx context.Context, obj runtime.Object, opts ...client.DeleteAllOfOption) error {
+	c.plan("delete all of", obj, "")
+	return nil
+}
+
+func (c *dryRunClient) Status() client.StatusWriter {
+	return &dryRunStatusWriter{client: c}
+}
+
+type dryRunStatusWriter struct {
+	client *dryRunClient
+}
+
+func (w *dryRunStatusWriter) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	w.client.plan("update status of", obj, "")
+	return nil
+}
+
+func (w *dryRunStatusWriter) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	w.client.plan("patch status of", obj, string(data))
+	return nil
+}
+
+// 记录计划执行的操作
+func (c *dryRunClient) plan(operation string, obj runtime.Object, detail string) {
+	kind := fmt.Sprintf("%T", obj)
+	if gvk, err := apiutil.GVKForObject(obj, c.scheme); err == nil {
+		kind = gvk.Kind
+	}
+	name := ""
+	if accessor, err := meta.Accessor(obj); err == nil {
+		name = accessor.GetNamespace() + "/" + accessor.GetName()
+	}
+	message := fmt.Sprintf("would %s %s %s", operation, kind, name)
+	if detail != "" {
+		message = message + ": " + detail
+	}
+	log.Info("dry run, skip writing to cluster.", "application", c.app.Namespace+"/"+c.app.Name, "operation", operation, "kind", kind, "name", name, "detail", detail)
+	// 每次调谐都会计划相同的操作, 同一资源的计划没有变化时不重复记录事件
+	key := fmt.Sprintf("%s/%s/planned/%s %s %s", c.app.Namespace, c.app.Name, operation, kind, name)
+	if c.recorded.changed(key, detail) {
+		c.recorder.Event(c.app, corev1.EventTypeNormal, ReasonPlanned, message)
+	}
+}
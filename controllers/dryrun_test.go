@@ -0,0 +1,63 @@
+/**
+ * 功能描述: 验证演练模式不写入集群, 只记录Planned事件
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestDryRunPlansWithoutWriting(t *testing.T) {
+	app := newTestApplication(newTestModule("web"))
+	r, recorder := newTestReconciler(t, app)
+
+	planner := r.withDryRun(app)
+	if err := planner.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+
+	deployList := &appsv1.DeploymentList{}
+	if err := r.List(context.TODO(), deployList, client.InNamespace(app.Namespace)); err != nil {
+		t.Fatal(err)
+	}
+	if len(deployList.Items) != 0 {
+		t.Errorf("expected no deployment in dry run, got %d", len(deployList.Items))
+	}
+	events := drainEvents(recorder)
+	expectEvent(t, events, "Normal Planned would create Deployment default/web")
+	if len(events) != 1 {
+		t.Errorf("expected only planned events, got %v", events)
+	}
+
+	// 下一次调谐计划相同的操作时不重复记录事件
+	planner = r.withDryRun(app)
+	if err := planner.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	if events := drainEvents(recorder); len(events) != 0 {
+		t.Errorf("expected unchanged plans not to be recorded again, got %v", events)
+	}
+}
+
+func TestDryRunSkipsMetrics(t *testing.T) {
+	app := newTestApplication(newTestModule("web"))
+	app.Namespace = "dry-run"
+	r, _ := newTestReconciler(t, app)
+	r.DryRun = true
+
+	if err := r.withDryRun(app).reconcileStatus(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile status: %v", err)
+	}
+	applicationPhasesLock.Lock()
+	_, isExist := applicationPhases[types.NamespacedName{Namespace: "dry-run", Name: "wsgw"}]
+	applicationPhasesLock.Unlock()
+	if isExist {
+		t.Errorf("expected planned status not to be recorded in metrics")
+	}
+}
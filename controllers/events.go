@@ -0,0 +1,96 @@
+/**
+ * 功能描述: 控制器记录的k8s事件原因及记录方法
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"fmt"
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"strings"
+	"sync"
+)
+
+// 事件原因, 所有事件都记录在application上
+var (
+	ReasonApplicationDeleting   = "Killing"
+	ReasonApplicationDeleted    = "SuccessfulDelete"
+	ReasonModuleCreated         = "ModuleCreated"
+	ReasonModuleUpdated         = "ModuleUpdated"
+	ReasonModuleDeleted         = "ModuleDeleted"
+	ReasonServiceUpdated        = "ServiceUpdated"
+	ReasonProxyUpdated          = "ProxyUpdated"
+	ReasonProxyConflict         = "ProxyConflict"
+	ReasonNetworkPolicyUpdated  = "NetworkPolicyUpdated"
+	ReasonNetworkPolicySkipped  = "NetworkPolicySkipped"
+	ReasonConfigNotWatched      = "ConfigNotWatched"
+	ReasonSecretCopied          = "SecretCopied"
+	ReasonSecretRejected        = "SecretRejected"
+	ReasonDependencyWaiting     = "DependencyWaiting"
+	ReasonNamespaceForbidden    = "NamespaceForbidden"
+	ReasonPlanned               = "Planned"
+	ReasonModuleAdopted         = "ModuleAdopted"
+	ReasonAdoptionSkipped       = "AdoptionSkipped"
+	ReasonModuleReleased        = "ModuleReleased"
+	ReasonModulePendingDeletion = "ModulePendingDeletion"
+)
+
+// 记录module相关的事件, 消息以module名称开头
+func (r *ApplicationReconciler) recordModuleEvent(app *appv1.Application, module, eventType, reason, messageFmt string, args ...interface{}) {
+	r.Recorder.Event(app, eventType, reason, fmt.Sprintf("module %s: %s", module, fmt.Sprintf(messageFmt, args...)))
+}
+
+// 最近一次记录的事件, 用于避免重复记录相同的事件
+type recordedEvents struct {
+	lock   sync.Mutex
+	events map[string]string
+}
+
+func newRecordedEvents() *recordedEvents {
+	return &recordedEvents{events: make(map[string]string)}
+}
+
+// 记录key对应的事件内容, 返回内容是否与上次不同. 未设置时总是返回true
+func (e *recordedEvents) changed(key, value string) bool {
+	if e == nil {
+		return true
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	isRecorded, isExist := e.events[key]
+	e.events[key] = value
+	return !isExist || isRecorded != value
+}
+
+// 清理应用的所有事件记录
+func (e *recordedEvents) forget(nsn types.NamespacedName) {
+	if e == nil {
+		return
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	prefix := nsn.Namespace + "/" + nsn.Name + "/"
+	for key := range e.events {
+		if strings.HasPrefix(key, prefix) {
+			delete(e.events, key)
+		}
+	}
+}
+
+// 记录module等待依赖资源的事件. 同一module的等待原因和module状态都没有变化时不重复记录,
+// 避免启动中的应用按间隔重新调谐时反复产生相同的事件
+func (r *ApplicationReconciler) recordModuleWaiting(app *appv1.Application, module, messageFmt string, args ...interface{}) {
+	message := fmt.Sprintf(messageFmt, args...)
+	phase := ""
+	for _, status := range app.Status.Modules {
+		if status.Name == module {
+			phase = status.Phase
+		}
+	}
+	key := fmt.Sprintf("%s/%s/waiting/%s", app.Namespace, app.Name, module)
+	if r.recorded.changed(key, phase+"/"+message) {
+		r.recordModuleEvent(app, module, corev1.EventTypeNormal, ReasonDependencyWaiting, "%s", message)
+	}
+}
@@ -0,0 +1,190 @@
+/**
+ * 功能描述: 使用fake client和fake recorder验证各调谐阶段记录的事件
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newTestReconciler(t testing.TB, objs ...runtime.Object) (*ApplicationReconciler, *record.FakeRecorder) {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := appv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	recorder := record.NewFakeRecorder(100)
+	return &ApplicationReconciler{
+		Client:   fake.NewFakeClientWithScheme(scheme, objs...),
+		Log:      log,
+		Scheme:   scheme,
+		Recorder: recorder,
+		recorded: newRecordedEvents(),
+	}, recorder
+}
+
+func newTestApplication(modules ...appv1.Module) *appv1.Application {
+	return &appv1.Application{
+		ObjectMeta: metav1.ObjectMeta{Name: "wsgw", Namespace: "default", UID: "wsgw-uid"},
+		Spec: appv1.ApplicationSpec{
+			DisplayName: "wsgw",
+			UserID:      2,
+			Modules:     modules,
+		},
+	}
+}
+
+func newTestModule(name string, proxies ...appv1.Proxy) appv1.Module {
+	replicas := int32(1)
+	return appv1.Module{
+		Name:       name,
+		AccessMode: appv1.AccessModeOutside,
+		Proxies:    proxies,
+		Template: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"name": name}},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"name": name}},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{
+						Name:  name,
+						Image: "192.168.31.132/appdeploy/tomcat:7",
+						Ports: []corev1.ContainerPort{{ContainerPort: 80, Protocol: corev1.ProtocolTCP}},
+					}},
+				},
+			},
+		},
+	}
+}
+
+func newTestProxyConfigMaps(tcpData map[string]string) []runtime.Object {
+	return []runtime.Object{
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: IngressTCPConfigMap, Namespace: KubeSystemNamespace},
+			Data:       tcpData,
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: IngressUDPConfigMap, Namespace: KubeSystemNamespace},
+		},
+	}
+}
+
+func drainEvents(recorder *record.FakeRecorder) []string {
+	events := make([]string, 0)
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func expectEvent(t *testing.T, events []string, prefix string) {
+	t.Helper()
+	for _, event := range events {
+		if strings.HasPrefix(event, prefix) {
+			return
+		}
+	}
+	t.Errorf("expected event with prefix %q, got %v", prefix, events)
+}
+
+func TestReconcileInstanceRecordsModuleCreated(t *testing.T) {
+	app := newTestApplication(newTestModule("web"))
+	r, recorder := newTestReconciler(t, app)
+
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	expectEvent(t, drainEvents(recorder), "Normal ModuleCreated module web: created deployment web")
+}
+
+func TestReconcileInstanceRecordsModuleDeleted(t *testing.T) {
+	app := newTestApplication(newTestModule("web"), newTestModule("api"))
+	objs := append(newTestProxyConfigMaps(nil), app)
+	r, recorder := newTestReconciler(t, objs...)
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	drainEvents(recorder)
+
+	app.Spec.Modules = app.Spec.Modules[:1]
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	expectEvent(t, drainEvents(recorder), "Normal ModuleDeleted module api:")
+}
+
+func TestReconcileSvcRecordsDependencyWaiting(t *testing.T) {
+	app := newTestApplication(newTestModule("web"))
+	r, recorder := newTestReconciler(t, app)
+
+	if err := r.reconcileSvc(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile svc: %v", err)
+	}
+	expectEvent(t, drainEvents(recorder), "Normal DependencyWaiting module web:")
+
+	// 等待原因和module状态没有变化时不重复记录
+	if err := r.reconcileSvc(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile svc: %v", err)
+	}
+	if events := drainEvents(recorder); len(events) != 0 {
+		t.Errorf("expected waiting event not to be recorded again, got %v", events)
+	}
+	app.Status.Modules = []appv1.ModuleStatus{{Name: "web", Phase: appv1.ModulePhaseStarting}}
+	if err := r.reconcileSvc(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile svc: %v", err)
+	}
+	expectEvent(t, drainEvents(recorder), "Normal DependencyWaiting module web:")
+
+	r.recorded.forget(types.NamespacedName{Namespace: app.Namespace, Name: app.Name})
+	if err := r.reconcileSvc(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile svc: %v", err)
+	}
+	expectEvent(t, drainEvents(recorder), "Normal DependencyWaiting module web:")
+}
+
+func TestReconcileProxyRecordsProxyConflict(t *testing.T) {
+	app := newTestApplication(newTestModule("web", appv1.Proxy{Protocol: "tcp", Port: 80, TargetPort: 9098}))
+	objs := append(newTestProxyConfigMaps(map[string]string{"9098": "other/db:3306"}), app)
+	r, recorder := newTestReconciler(t, objs...)
+
+	if err := r.reconcileProxy(context.TODO(), app); err == nil {
+		t.Fatal("expected port conflict error")
+	}
+	events := drainEvents(recorder)
+	expectEvent(t, events, "Warning ProxyConflict module web: tcp port 9098 is already used by other/db:3306")
+	for _, event := range events {
+		if strings.Contains(event, ReasonProxyUpdated) {
+			t.Errorf("unexpected proxy update event on conflict: %s", event)
+		}
+	}
+}
+
+func TestReconcileProxyRecordsProxyUpdated(t *testing.T) {
+	app := newTestApplication(newTestModule("web", appv1.Proxy{Protocol: "tcp", Port: 80, TargetPort: 9098}))
+	objs := append(newTestProxyConfigMaps(nil), app)
+	r, recorder := newTestReconciler(t, objs...)
+
+	if err := r.reconcileProxy(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile proxy: %v", err)
+	}
+	expectEvent(t, drainEvents(recorder), "Normal ProxyUpdated module web: updated ingress tcp proxy rules")
+}
@@ -0,0 +1,68 @@
+/**
+ * 功能描述: module的健康检查, 为主容器生成探针并根据pod的Ready状态汇总检查结果
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// 获取module的主容器: 与module同名的容器, 没有同名容器时为第一个容器
+func mainContainer(podSpec *corev1.PodSpec, moduleName string) *corev1.Container {
+	for i := range podSpec.Containers {
+		if podSpec.Containers[i].Name == moduleName {
+			return &podSpec.Containers[i]
+		}
+	}
+	if len(podSpec.Containers) == 0 {
+		return nil
+	}
+	return &podSpec.Containers[0]
+}
+
+// 根据module的pod的Ready状态汇总健康检查结果, 以最近一次发生变化的Ready状态作为最后的检查结果.
+// 控制器不watch pod, 直接从apiserver按标签读取, 避免缓存全集群的pod
+func (r *ApplicationReconciler) moduleHealth(ctx context.Context, app *appv1.Application, moduleName string) (*appv1.ModuleHealth, error) {
+	podList := &corev1.PodList{}
+	if err := r.apiReader().List(ctx, podList, client.InNamespace(app.Namespace), applabels.SelectorForModule(app.Name, moduleName)); err != nil {
+		log.Error(err, "failed to list pods of module.", "namespace", app.Namespace, "moduleName", moduleName)
+		return nil, err
+	}
+	health := &appv1.ModuleHealth{}
+	var last *corev1.PodCondition
+	for i := range podList.Items {
+		pod := &podList.Items[i]
+		if pod.DeletionTimestamp != nil {
+			continue
+		}
+		health.TotalPods += 1
+		for j := range pod.Status.Conditions {
+			condition := &pod.Status.Conditions[j]
+			if condition.Type != corev1.PodReady {
+				continue
+			}
+			if condition.Status == corev1.ConditionTrue {
+				health.ReadyPods += 1
+			}
+			if last == nil || last.LastTransitionTime.Before(&condition.LastTransitionTime) {
+				last = condition
+			}
+		}
+	}
+	if last == nil {
+		return health, nil
+	}
+	health.LastProbeTime = last.LastTransitionTime.DeepCopy()
+	if last.Status == corev1.ConditionTrue {
+		health.LastProbeResult = appv1.ProbeResultReady
+	} else {
+		health.LastProbeResult = appv1.ProbeResultNotReady
+		health.Message = last.Message
+	}
+	return health, nil
+}
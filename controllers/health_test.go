@@ -0,0 +1,95 @@
+/**
+ * 功能描述: 验证module健康检查探针的生成以及检查结果的汇总
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestHealthCheckGeneratesMissingProbes(t *testing.T) {
+	module := newTestModule("web")
+	module.HealthCheck = &appv1.HealthCheck{Path: "/healthz", Port: 80, SuccessThreshold: 2}
+	sidecar := corev1.Container{Name: "sidecar", Image: "busybox"}
+	existing := &corev1.Probe{Handler: corev1.Handler{Exec: &corev1.ExecAction{Command: []string{"true"}}}}
+	module.Template.Template.Spec.Containers[0].LivenessProbe = existing
+	module.Template.Template.Spec.Containers = append([]corev1.Container{sidecar}, module.Template.Template.Spec.Containers...)
+	app := newTestApplication(module)
+
+	deploy, err := makeModule2Deployment(&app.Spec.Modules[0], app, ModuleDefaults{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	containers := deploy.Spec.Template.Spec.Containers
+	if containers[0].ReadinessProbe != nil || containers[0].LivenessProbe != nil {
+		t.Errorf("expected no probes on the sidecar container")
+	}
+	readiness := containers[1].ReadinessProbe
+	if readiness == nil || readiness.HTTPGet == nil || readiness.HTTPGet.Path != "/healthz" || readiness.SuccessThreshold != 2 || readiness.PeriodSeconds != 10 {
+		t.Errorf("unexpected readiness probe %v", readiness)
+	}
+	if containers[1].LivenessProbe.Exec == nil {
+		t.Errorf("expected the liveness probe of the template to be kept, got %v", containers[1].LivenessProbe)
+	}
+	if app.Spec.Modules[0].Template.Template.Spec.Containers[1].ReadinessProbe != nil {
+		t.Errorf("expected module template not to be mutated")
+	}
+
+	module.HealthCheck = &appv1.HealthCheck{Type: appv1.HealthCheckTCP, Port: 80}
+	container := corev1.Container{}
+	module.HealthCheck.ApplyDefaults(&container)
+	if container.LivenessProbe.TCPSocket == nil || container.LivenessProbe.SuccessThreshold != 1 {
+		t.Errorf("unexpected liveness probe %v", container.LivenessProbe)
+	}
+}
+
+func newTestPod(app *appv1.Application, name string, ready corev1.ConditionStatus, transition time.Time) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: app.Namespace,
+			Name:      name,
+			Labels:    map[string]string{applabels.AppName: app.Name, applabels.ModuleName: "web"},
+		},
+		Status: corev1.PodStatus{Conditions: []corev1.PodCondition{{
+			Type:               corev1.PodReady,
+			Status:             ready,
+			Message:            "containers with unready status: [web]",
+			LastTransitionTime: metav1.Time{Time: transition},
+		}}},
+	}
+}
+
+func TestAggregateModuleHealth(t *testing.T) {
+	app := newTestApplication(newTestModule("web"))
+	now := time.Now()
+	objs := []runtime.Object{
+		app,
+		newTestPod(app, "web-1", corev1.ConditionTrue, now.Add(-time.Hour)),
+		newTestPod(app, "web-2", corev1.ConditionFalse, now.Add(-time.Minute)),
+	}
+	r, _ := newTestReconciler(t, append(newTestProxyConfigMaps(nil), objs...)...)
+	r.AggregateHealth = true
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	if err := r.reconcileStatus(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile status: %v", err)
+	}
+
+	health := app.Status.Modules[0].Health
+	if health == nil || health.TotalPods != 2 || health.ReadyPods != 1 {
+		t.Fatalf("unexpected module health %v", health)
+	}
+	if health.LastProbeResult != appv1.ProbeResultNotReady || health.Message == "" || health.LastProbeTime == nil {
+		t.Errorf("expected the latest readiness change to be reported, got %v", health)
+	}
+}
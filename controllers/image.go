@@ -0,0 +1,65 @@
+/**
+ * 功能描述: 对module中容器镜像的仓库地址和tag进行改写
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"strings"
+)
+
+// 获取应用生效的镜像仓库地址, app中的设置优先于控制器的全局配置
+func imageRegistryFor(app *appv1.Application, defaultRegistry string) string {
+	if app.Spec.ImageRegistry != "" {
+		return app.Spec.ImageRegistry
+	}
+	return defaultRegistry
+}
+
+// 改写pod中所有容器和初始化容器的镜像
+func rewritePodImages(podSpec *corev1.PodSpec, registry string, tags map[string]string) {
+	for i := range podSpec.InitContainers {
+		container := &podSpec.InitContainers[i]
+		container.Image = rewriteImage(container.Image, registry, tags[container.Name])
+	}
+	for i := range podSpec.Containers {
+		container := &podSpec.Containers[i]
+		container.Image = rewriteImage(container.Image, registry, tags[container.Name])
+	}
+}
+
+// 替换镜像的仓库地址和tag, registry或tag为空时保留原值
+func rewriteImage(image, registry, tag string) string {
+	if image == "" {
+		return image
+	}
+	repository := image
+	// 拆分镜像的仓库地址
+	if i := strings.Index(repository, "/"); i > 0 && isRegistryHost(repository[:i]) {
+		if registry == "" {
+			registry = repository[:i]
+		}
+		repository = repository[i+1:]
+	}
+	if tag != "" {
+		// 去掉原有的digest和tag
+		if i := strings.Index(repository, "@"); i >= 0 {
+			repository = repository[:i]
+		}
+		if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+			repository = repository[:i]
+		}
+		repository = repository + ":" + tag
+	}
+	if registry == "" {
+		return repository
+	}
+	return strings.TrimSuffix(registry, "/") + "/" + repository
+}
+
+// 判断镜像名称的第一段是否为仓库地址, 与docker的判断规则保持一致
+func isRegistryHost(s string) bool {
+	return strings.ContainsAny(s, ".:") || s == "localhost"
+}
@@ -0,0 +1,52 @@
+/**
+ * 功能描述: 验证镜像仓库地址和tag的改写规则
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"testing"
+)
+
+func TestRewriteImage(t *testing.T) {
+	cases := []struct {
+		image    string
+		registry string
+		tag      string
+		expected string
+	}{
+		{image: "", registry: "reg.io", tag: "1", expected: ""},
+		{image: "nginx", expected: "nginx"},
+		{image: "nginx", registry: "reg.io:5000", expected: "reg.io:5000/nginx"},
+		{image: "nginx", registry: "reg.io/", tag: "1.17", expected: "reg.io/nginx:1.17"},
+		{image: "library/nginx:1.17", registry: "reg.io", expected: "reg.io/library/nginx:1.17"},
+		{image: "192.168.31.132/appdeploy/tomcat:7", registry: "reg.io", expected: "reg.io/appdeploy/tomcat:7"},
+		{image: "192.168.31.132/appdeploy/tomcat:7", tag: "8", expected: "192.168.31.132/appdeploy/tomcat:8"},
+		{image: "localhost:5000/app:v1", tag: "v2", expected: "localhost:5000/app:v2"},
+		{image: "localhost/app", registry: "reg.io", expected: "reg.io/app"},
+		{image: "reg.io:5000/app", tag: "v2", expected: "reg.io:5000/app:v2"},
+		{image: "reg.io/app@sha256:abc", registry: "other.io", expected: "other.io/app@sha256:abc"},
+		{image: "reg.io/app:v1@sha256:abc", tag: "v2", expected: "reg.io/app:v2"},
+	}
+	for _, c := range cases {
+		if actual := rewriteImage(c.image, c.registry, c.tag); actual != c.expected {
+			t.Errorf("rewriteImage(%q, %q, %q) = %q, expected %q", c.image, c.registry, c.tag, actual, c.expected)
+		}
+	}
+}
+
+func TestIsRegistryHost(t *testing.T) {
+	cases := map[string]bool{
+		"reg.io":         true,
+		"reg:5000":       true,
+		"192.168.31.132": true,
+		"localhost":      true,
+		"library":        false,
+		"appdeploy":      false,
+	}
+	for host, expected := range cases {
+		if actual := isRegistryHost(host); actual != expected {
+			t.Errorf("isRegistryHost(%q) = %v, expected %v", host, actual, expected)
+		}
+	}
+}
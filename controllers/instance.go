@@ -7,8 +7,8 @@ package controllers
 
 import (
 	"context"
-	"fmt"
 	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
 	v1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
@@ -19,16 +19,20 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
-func (r *ApplicationReconciler) reconcileInstance(app *appv1.Application) error {
+func (r *ApplicationReconciler) reconcileInstance(ctx context.Context, app *appv1.Application) error {
 	newDeploys := make(map[string]*v1.Deployment)
 	for i := range app.Spec.Modules {
 		module := &app.Spec.Modules[i]
 
-		deploy, err := makeModule2Deployment(module, app)
+		deploy, err := makeModule2Deployment(module, app, r.Defaults)
 		if err != nil {
 			log.Error(err, "failed to make module to deployment.", "moduleName", module.Name)
 			return err
 		}
+		if err := r.setConfigChecksum(ctx, app, module, &deploy.Spec.Template); err != nil {
+			log.Error(err, "failed to compute config checksum for module.", "moduleName", module.Name)
+			return err
+		}
 		if err := controllerutil.SetControllerReference(app, deploy, r.Scheme); err != nil {
 			log.Error(err, "failed to set Owner reference for module", "moduleName", module.Name)
 			return nil
@@ -37,127 +41,196 @@ func (r *ApplicationReconciler) reconcileInstance(app *appv1.Application) error
 		newDeploys[deploy.Name] = deploy
 
 		found := &v1.Deployment{}
-		err = r.Get(context.TODO(), types.NamespacedName{Name: deploy.Name, Namespace: deploy.Namespace}, found)
+		err = r.Get(ctx, types.NamespacedName{Name: deploy.Name, Namespace: deploy.Namespace}, found)
 		// deployment is not found
 		if err != nil && apierrs.IsNotFound(err) {
 			log.Info("the spec module is not found and create new deployment.", "namespace", app.Namespace, "name", deploy.Name)
-			if err = r.Create(context.TODO(), deploy); err != nil {
+			if err = r.Create(ctx, deploy); err != nil {
 				log.Error(err, "failed to create new deployment")
 				return err
 			}
+			r.recordModuleEvent(app, module.Name, corev1.EventTypeNormal, ReasonModuleCreated, "created deployment %s", deploy.Name)
 		} else if err != nil {
 			// query failed
 			log.Error(err, "failed to get deployment.", "namespace", app.Namespace, "name", deploy.Name)
 			return err
-		} else if !reflect.DeepEqual(deploy.Spec, found.Spec) {
+		} else if !metav1.IsControlledBy(found, app) {
+			// 集群中已存在的deployment不受当前应用控制, 开启接管时纳入管理
+			if err := r.adoptDeployment(ctx, app, module, deploy, found); err != nil {
+				return err
+			}
+		} else if cancelPendingDeletion(found) {
+			// 待删除的module重新加入spec, 恢复为spec中的定义, 不回写缩容后的副本数
+			syncDeletionProtection(deploy, found)
+			found.Spec = deploy.Spec
+			if err := r.Update(ctx, found); err != nil {
+				log.Error(err, "failed to cancel pending deletion of deployment.", "namespace", app.Namespace, "name", found.Name)
+				return err
+			}
+			r.recordModuleEvent(app, module.Name, corev1.EventTypeNormal, ReasonModuleUpdated, "cancelled pending deletion of deployment %s", found.Name)
+		} else if metadataChanged := syncModuleMetadata(deploy, found); syncDeletionProtection(deploy, found) || metadataChanged || !reflect.DeepEqual(deploy.Spec, found.Spec) {
 			// 如果版本有更新,则进行update
 			// 如果replica数量变化,以集群内状态为准,并反向更新到App.Spec,防止影响到hpa弹性伸缩
 			if *deploy.Spec.Replicas != *found.Spec.Replicas {
 				log.Info("the replicas was changed, will apply the deployment replicas from cluster", "apply", found.Spec.Replicas, "origin", deploy.Spec.Replicas)
 				app.Spec.Modules[i].Template.Replicas = found.Spec.Replicas
-				err := r.Update(context.Background(), app)
+				err := r.Update(ctx, app)
 				if err != nil {
 					log.Error(err, "failed to update app module replicas.", "app", app.Name, "module", deploy.Name)
 					return err
 				}
-				r.Recorder.Event(app, "Normal", "SuccessfulUpdated", fmt.Sprintf("Updated module %s replica to %d in %s/%s", found.Name, found.Spec.Replicas, app.Namespace, app.Spec.DisplayName))
+				r.recordModuleEvent(app, module.Name, corev1.EventTypeNormal, ReasonModuleUpdated, "applied replicas %d from deployment %s", *found.Spec.Replicas, found.Name)
 				log.Info("Successfully update application module replicas.")
 				return nil
 			}
 			found.Spec = deploy.Spec
 			// 清空资源版本, 防止与冲突
 			found.ResourceVersion = ""
-			err = r.Update(context.TODO(), found)
+			err = r.Update(ctx, found)
 			if err != nil {
 				log.Error(err, "failed to update deployment.", "namespace", app.Namespace, "name", found.Name)
 				return err
 			}
-			r.Recorder.Event(app, "Normal", "SuccessfulUpdated", fmt.Sprintf("Updated module %s in %s/%s", found.Name, app.Namespace, app.Spec.DisplayName))
+			r.recordModuleEvent(app, module.Name, corev1.EventTypeNormal, ReasonModuleUpdated, "updated deployment %s", found.Name)
 			log.Info("found deployment has changed and updating by spec module.", "namespace", deploy.Namespace, "name", deploy.Name)
 		}
 
 	}
 
 	// 判断是否主动删除module
-	return r.cleanUpDeployment(app, newDeploys)
+	return r.cleanUpDeployment(ctx, app, newDeploys)
 }
 
-func (r *ApplicationReconciler) cleanUpDeployment(app *appv1.Application, newDeployList map[string]*v1.Deployment) error {
-	ctx := context.Background()
-
+func (r *ApplicationReconciler) cleanUpDeployment(ctx context.Context, app *appv1.Application, newDeployList map[string]*v1.Deployment) error {
 	deploymentList := &v1.DeploymentList{}
-	labels := make(map[string]string)
-	labels[APPNameLabel] = app.Name
+	pending := make([]appv1.ModuleStatus, 0)
 
-	if err := r.List(ctx, deploymentList, client.InNamespace(app.Namespace), client.MatchingLabels{APPNameLabel: app.Name}); err != nil {
-		log.Error(err, "failed to list deployment by namespace and label.", "namespace", app.Namespace, "label", APPNameLabel)
+	if err := r.List(ctx, deploymentList, client.InNamespace(app.Namespace), applabels.SelectorForApp(app.Name)); err != nil {
+		log.Error(err, "failed to list deployment by namespace and label.", "namespace", app.Namespace, "label", applabels.AppName)
 		return err
 	}
 
 	for _, oldDeploy := range deploymentList.Items {
 		// 判断属于当前应用的deploy是否还在app.spec内指定,如果未指定,则需要清理该deployment及其相关的资源配置
 		if _, isExist := newDeployList[oldDeploy.Name]; isExist == false {
+			moduleName := moduleNameOf(&oldDeploy)
+			renamed := renamedModule(app, &oldDeploy)
+			if renamed != nil {
+				// 重命名或修改命名方式的module在新deployment可用并迁移proxy规则后再删除
+				ready, err := r.moveRenamedModule(ctx, app, renamed, &oldDeploy)
+				if err != nil {
+					return err
+				} else if !ready {
+					continue
+				}
+			} else {
+				// 开启删除保护或延迟删除时先缩容到0
+				status, err := r.markPendingDeletion(ctx, app, &oldDeploy)
+				if err != nil {
+					return err
+				}
+				if status != nil {
+					pending = append(pending, *status)
+					continue
+				}
+			}
+
 			log.Info("Find an isolated deployment. deleting it.", "namespace", app.Namespace, "deploymentName", oldDeploy.Name)
-			r.Recorder.Event(app, "Normal", "Deleting", fmt.Sprintf("Deleting module %s  in %s/%s", oldDeploy.Name, app.Namespace, app.Spec.DisplayName))
 
-			// 如果存在ingress config map ,进行删除
-			err := r.cleanUpProxy(types.NamespacedName{Name: oldDeploy.Name, Namespace: app.Namespace})
+			// 如果存在ingress config map ,进行删除, 重命名的module已经转移了proxy规则
+			if renamed == nil {
+				if err := r.cleanUpProxy(ctx, app, moduleName); err != nil {
+					log.Error(err, "failed to delete ingress configmap.", "namespace", app.Namespace, "deploymentName", oldDeploy.Name)
+					return err
+				}
+			}
+
+			// 删除module对应的network policy
+			err := r.cleanUpNetworkPolicy(ctx, app, types.NamespacedName{Name: oldDeploy.Name, Namespace: app.Namespace})
 			if err != nil {
-				log.Error(err, "failed to delete ingress configmap.", "namespace", app.Namespace, "deploymentName", oldDeploy.Name)
+				log.Error(err, "failed to delete network policy.", "namespace", app.Namespace, "deploymentName", oldDeploy.Name)
 				return err
 			}
-			r.Recorder.Event(app, "Normal", "SuccessfulDelete", fmt.Sprintf("Deleted proxy config for moudle %s  in %s/%s", oldDeploy.Name, app.Namespace, app.Spec.DisplayName))
 
 			// 如果存在svc, 则删除对应的svc
 			svc := &corev1.Service{}
-			err = r.Get(context.TODO(), types.NamespacedName{Namespace: oldDeploy.Namespace, Name: oldDeploy.Name}, svc)
+			err = r.Get(ctx, types.NamespacedName{Namespace: oldDeploy.Namespace, Name: oldDeploy.Name}, svc)
 			if err == nil {
 				// 存在svc 进行删除
-				err = r.Delete(context.TODO(), svc)
+				err = r.Delete(ctx, svc)
 				if err != nil {
 					log.Error(err, "failed to delete the not defined svc.", "namespace", app.Namespace, "deploymentName", oldDeploy.Name)
 					return err
 				}
 			}
-			r.Recorder.Event(app, "Normal", "SuccessfulDelete", fmt.Sprintf("Deleted svc for moudle %s  in %s/%s", oldDeploy.Name, app.Namespace, app.Spec.DisplayName))
 
 			// 孤立的deployment, 进行删除
-			err = r.Delete(context.TODO(), &oldDeploy)
+			err = r.Delete(ctx, &oldDeploy)
 			if err != nil {
 				log.Error(err, "failed to delete the not defined deployment.", "namespace", app.Namespace, "deploymentName", oldDeploy.Name)
 				return err
 			}
-			r.Recorder.Event(app, "Normal", "SuccessfulDelete", fmt.Sprintf("Deleted moudle %s  in %s/%s", oldDeploy.Name, app.Namespace, app.Spec.DisplayName))
-
+			r.recordModuleEvent(app, moduleName, corev1.EventTypeNormal, ReasonModuleDeleted, "deleted deployment %s, svc and proxy rules of the module removed from spec", oldDeploy.Name)
 		}
 	}
 
+	// 记录待删除的module
+	if setPendingDeletionModules(&app.Status, pending) {
+		if err := r.Status().Update(ctx, app); err != nil {
+			log.Error(err, "failed to update pending deletion modules.", "namespace", app.Namespace, "applicationName", app.Name)
+			return err
+		}
+	}
 	return nil
 }
 
-func makeModule2Deployment(module *appv1.Module, app *appv1.Application) (*v1.Deployment, error) {
-	labels := app.Labels
-	if labels == nil {
-		labels = make(map[string]string)
-	}
-	labels[APPNameLabel] = app.Name
-	labels[ModuleNameLabel] = module.Name
-	labels[DeploymentType] = "crd"
+// 控制器级别的module默认配置, 由启动参数指定
+type ModuleDefaults struct {
+	// 全局镜像仓库地址, 为空时不改写镜像
+	ImageRegistry string
+	// 默认的镜像拉取secret名称, 注入到所有module的pod中
+	ImagePullSecret string
+	// 镜像拉取secret的来源namespace, 设置后自动复制到应用所在的namespace
+	ImagePullSecretNamespace string
+	// 除默认secret外, 允许应用通过spec.imagePullSecrets从来源namespace复制的secret名称
+	ImagePullSecretAllowlist []string
+}
 
-	deploySpec := module.Template
-	if deploySpec.Template.Labels == nil {
-		deploySpec.Template.Labels = make(map[string]string)
+func makeModule2Deployment(module *appv1.Module, app *appv1.Application, defaults ModuleDefaults) (*v1.Deployment, error) {
+	// 深拷贝template, 避免改写镜像时修改app中的定义
+	deploySpec := *module.Template.DeepCopy()
+	rewritePodImages(&deploySpec.Template.Spec, imageRegistryFor(app, defaults.ImageRegistry), module.ImageTags)
+	injectImagePullSecrets(&deploySpec.Template.Spec, imagePullSecretsFor(app, defaults.ImagePullSecret))
+	// 为未指定requests/limits的容器设置应用的默认资源
+	for i := range deploySpec.Template.Spec.InitContainers {
+		app.Spec.Resources.ApplyDefaults(&deploySpec.Template.Spec.InitContainers[i])
+	}
+	for i := range deploySpec.Template.Spec.Containers {
+		app.Spec.Resources.ApplyDefaults(&deploySpec.Template.Spec.Containers[i])
+	}
+	// 为主容器设置template中未定义的健康检查探针
+	if container := mainContainer(&deploySpec.Template.Spec, module.Name); container != nil {
+		module.HealthCheck.ApplyDefaults(container)
+	}
+	deploySpec.Template.Labels = modulePodLabels(app, module, deploySpec.Template.Labels)
+	name := app.ModuleResourceName(module.Name)
+	if name != module.Name {
+		// 资源名称带应用前缀时, svc通过name标签选择pod, 同步改写pod和selector中的name标签, 避免选中其他应用的pod
+		deploySpec.Template.Labels[applabels.ServiceSelector] = name
+		if deploySpec.Selector != nil {
+			if _, isExist := deploySpec.Selector.MatchLabels[applabels.ServiceSelector]; isExist {
+				deploySpec.Selector.MatchLabels[applabels.ServiceSelector] = name
+			}
+		}
 	}
-	deploySpec.Template.Labels[APPNameLabel] = app.Spec.DisplayName
-	deploySpec.Template.Labels[ModuleNameLabel] = module.Name
-	deploySpec.Template.Labels[PodType] = "crd"
 
 	// 判断是否需要拉取软件包
 	deploy := &v1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      module.Name,
-			Namespace: app.Namespace,
-			Labels:    labels,
+			Name:        name,
+			Namespace:   app.Namespace,
+			Labels:      moduleLabels(app, module),
+			Annotations: moduleAnnotations(app, module),
 		},
 		Spec: deploySpec,
 	}
@@ -0,0 +1,101 @@
+/**
+ * 功能描述: 生成module相关资源的标签和注解, 不修改应用中的map
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
+	v1 "k8s.io/api/apps/v1"
+	"strconv"
+)
+
+// 控制器添加到资源上的标签, 释放资源时需要去掉
+var managedLabelKeys = []string{applabels.AppName, applabels.ModuleName, applabels.DeploymentType, applabels.UserID, applabels.K8sName, applabels.K8sInstance, applabels.K8sPartOf, applabels.K8sManagedBy}
+
+func copyLabels(labels map[string]string) map[string]string {
+	result := make(map[string]string, len(labels))
+	for key, value := range labels {
+		result[key] = value
+	}
+	return result
+}
+
+// module的通用标签, 标签值只使用应用和module的名称
+func recommendedLabels(app *appv1.Application, module *appv1.Module) map[string]string {
+	return map[string]string{
+		applabels.K8sName:      module.Name,
+		applabels.K8sInstance:  app.ModuleResourceName(module.Name),
+		applabels.K8sPartOf:    app.Name,
+		applabels.K8sManagedBy: applabels.ManagedByValue,
+	}
+}
+
+// deployment, svc等资源的标签, 在应用标签的副本上添加控制器的标签
+func moduleLabels(app *appv1.Application, module *appv1.Module) map[string]string {
+	labels := copyLabels(app.Labels)
+	for key, value := range recommendedLabels(app, module) {
+		labels[key] = value
+	}
+	labels[applabels.AppName] = app.Name
+	labels[applabels.ModuleName] = module.Name
+	labels[applabels.DeploymentType] = "crd"
+	labels[applabels.UserID] = strconv.Itoa(app.Spec.UserID)
+	return labels
+}
+
+// pod模板的标签, 在module模板标签的副本上添加控制器的标签, NetworkPolicy根据这些标签选择pod
+func modulePodLabels(app *appv1.Application, module *appv1.Module, templateLabels map[string]string) map[string]string {
+	labels := copyLabels(templateLabels)
+	for key, value := range recommendedLabels(app, module) {
+		labels[key] = value
+	}
+	labels[applabels.AppName] = app.Name
+	labels[applabels.ModuleName] = module.Name
+	labels[applabels.PodType] = "crd"
+	labels[applabels.UserID] = strconv.Itoa(app.Spec.UserID)
+	return labels
+}
+
+// deployment的注解, 显示名称不写入pod模板, 修改显示名称时不会触发滚动更新
+func moduleAnnotations(app *appv1.Application, module *appv1.Module) map[string]string {
+	annotations := make(map[string]string)
+	if app.Spec.DisplayName != "" {
+		annotations[applabels.DisplayNameAnnotation] = app.Spec.DisplayName
+	}
+	if module.DeletionProtection {
+		annotations[applabels.DeletionProtectionAnnotation] = "true"
+	}
+	if len(annotations) == 0 {
+		return nil
+	}
+	return annotations
+}
+
+// 将期望的标签和显示名称注解同步到集群中的deployment, 保留其他标签, 返回是否发生了变更
+func syncModuleMetadata(deploy, found *v1.Deployment) bool {
+	changed := false
+	if found.Labels == nil {
+		found.Labels = make(map[string]string)
+	}
+	for key, value := range deploy.Labels {
+		if current, isExist := found.Labels[key]; !isExist || current != value {
+			found.Labels[key] = value
+			changed = true
+		}
+	}
+	value, isExist := deploy.Annotations[applabels.DisplayNameAnnotation]
+	if found.Annotations[applabels.DisplayNameAnnotation] == value {
+		return changed
+	}
+	if !isExist {
+		delete(found.Annotations, applabels.DisplayNameAnnotation)
+		return true
+	}
+	if found.Annotations == nil {
+		found.Annotations = make(map[string]string)
+	}
+	found.Annotations[applabels.DisplayNameAnnotation] = value
+	return true
+}
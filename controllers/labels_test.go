@@ -0,0 +1,78 @@
+/**
+ * 功能描述: 验证module资源的标签和注解
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
+	appsv1 "k8s.io/api/apps/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+func TestModuleLabelsDoNotMutateApplication(t *testing.T) {
+	app := newTestApplication(newTestModule("web"))
+	app.Labels = map[string]string{"team": "gw"}
+	app.Spec.DisplayName = "网关应用"
+
+	deploy, err := makeModule2Deployment(&app.Spec.Modules[0], app, ModuleDefaults{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(app.Labels) != 1 {
+		t.Errorf("expected application labels not to be mutated, got %v", app.Labels)
+	}
+	if _, isExist := app.Spec.Modules[0].Template.Template.Labels[applabels.AppName]; isExist {
+		t.Errorf("expected module template labels not to be mutated")
+	}
+	if deploy.Labels["team"] != "gw" || deploy.Labels[applabels.AppName] != app.Name || deploy.Labels[applabels.K8sPartOf] != app.Name {
+		t.Errorf("unexpected deployment labels %v", deploy.Labels)
+	}
+	podLabels := deploy.Spec.Template.Labels
+	if podLabels[applabels.AppName] != app.Name || podLabels[applabels.K8sName] != "web" || podLabels[applabels.K8sManagedBy] != applabels.ManagedByValue {
+		t.Errorf("unexpected pod labels %v", podLabels)
+	}
+	if deploy.Annotations[applabels.DisplayNameAnnotation] != app.Spec.DisplayName {
+		t.Errorf("expected display name in annotations, got %v", deploy.Annotations)
+	}
+
+	svc, err := makeSvcFromDeploy(deploy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.Labels["extra"] = "value"
+	if _, isExist := deploy.Labels["extra"]; isExist {
+		t.Errorf("expected svc labels to be copied from deployment")
+	}
+}
+
+func TestSelectorForModule(t *testing.T) {
+	app := newTestApplication(newTestModule("web"), newTestModule("api"))
+	other := newTestApplication(newTestModule("web"))
+	other.Name = "other"
+	other.Namespace = "prod"
+	r, _ := newTestReconciler(t, append(newTestProxyConfigMaps(nil), app, other)...)
+	for _, item := range []*appv1.Application{app, other} {
+		if err := r.reconcileInstance(context.TODO(), item); err != nil {
+			t.Fatalf("reconcile instance: %v", err)
+		}
+	}
+
+	deploys := &appsv1.DeploymentList{}
+	if err := r.List(context.TODO(), deploys, applabels.SelectorForApp(app.Name)); err != nil {
+		t.Fatal(err)
+	}
+	if len(deploys.Items) != 2 {
+		t.Errorf("expected 2 deployments of the application, got %d", len(deploys.Items))
+	}
+	if err := r.List(context.TODO(), deploys, client.InNamespace(app.Namespace), applabels.SelectorForModule(app.Name, "web")); err != nil {
+		t.Fatal(err)
+	}
+	if len(deploys.Items) != 1 || deploys.Items[0].Name != "web" {
+		t.Errorf("expected only the web deployment, got %v", deploys.Items)
+	}
+}
@@ -0,0 +1,113 @@
+/**
+ * 功能描述: 应用和module运行状态的prometheus监控指标
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sync"
+)
+
+var (
+	applicationModulesTotal = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "application_modules_total",
+		Help: "Number of modules defined in the application",
+	}, []string{"namespace", "app"})
+	applicationModulesRunning = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "application_modules_running",
+		Help: "Number of modules of the application with available replicas",
+	}, []string{"namespace", "app"})
+	applicationModulesStarting = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "application_modules_starting",
+		Help: "Number of modules of the application without available replicas yet",
+	}, []string{"namespace", "app"})
+	applicationModulesStopped = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "application_modules_stopped",
+		Help: "Number of modules of the application scaled to zero",
+	}, []string{"namespace", "app"})
+	applicationStatus = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "application_status",
+		Help: "Number of applications in each status phase",
+	}, []string{"phase"})
+	proxyPortConflictsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "application_proxy_port_conflicts_total",
+		Help: "Total number of proxy rules rejected because the ingress port is already used",
+	}, []string{"namespace", "app", "protocol"})
+	reconcileFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "application_reconcile_failures_total",
+		Help: "Total number of failed reconciles per phase",
+	}, []string{"phase"})
+	reconcileTimeoutsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "application_reconcile_timeouts_total",
+		Help: "Total number of reconciles cancelled by the reconcile timeout per phase",
+	}, []string{"phase"})
+
+	// 记录每个应用当前的状态, 用于统计各状态的应用数量
+	applicationPhases     = make(map[types.NamespacedName]string)
+	applicationPhasesLock sync.Mutex
+	// 出现过的状态, 没有应用处于该状态时指标置为0
+	knownApplicationPhases = make(map[string]bool)
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		applicationModulesTotal,
+		applicationModulesRunning,
+		applicationModulesStarting,
+		applicationModulesStopped,
+		applicationStatus,
+		proxyPortConflictsTotal,
+		reconcileFailuresTotal,
+		reconcileTimeoutsTotal,
+	)
+}
+
+// 根据应用状态更新监控指标
+func recordApplicationMetrics(app *appv1.Application) {
+	applicationModulesTotal.WithLabelValues(app.Namespace, app.Name).Set(float64(app.Status.TotalModuleNumber))
+	applicationModulesRunning.WithLabelValues(app.Namespace, app.Name).Set(float64(app.Status.RunningModuleNumber))
+	applicationModulesStarting.WithLabelValues(app.Namespace, app.Name).Set(float64(app.Status.StartingModuleNumber))
+	applicationModulesStopped.WithLabelValues(app.Namespace, app.Name).Set(float64(app.Status.StoppedModuleNumber))
+	setApplicationPhase(types.NamespacedName{Namespace: app.Namespace, Name: app.Name}, app.Status.Status)
+}
+
+// 记录应用的监控指标, 演练模式下状态没有写入集群, 不记录
+func (r *ApplicationReconciler) recordMetrics(app *appv1.Application) {
+	if r.DryRun {
+		return
+	}
+	recordApplicationMetrics(app)
+}
+
+// 应用删除后清理对应的监控指标
+func forgetApplicationMetrics(nsn types.NamespacedName) {
+	applicationModulesTotal.DeleteLabelValues(nsn.Namespace, nsn.Name)
+	applicationModulesRunning.DeleteLabelValues(nsn.Namespace, nsn.Name)
+	applicationModulesStarting.DeleteLabelValues(nsn.Namespace, nsn.Name)
+	applicationModulesStopped.DeleteLabelValues(nsn.Namespace, nsn.Name)
+	setApplicationPhase(nsn, "")
+}
+
+// 更新应用的状态并重新统计各状态的应用数量, phase为空时表示应用已删除
+func setApplicationPhase(nsn types.NamespacedName, phase string) {
+	applicationPhasesLock.Lock()
+	defer applicationPhasesLock.Unlock()
+	if phase == "" {
+		delete(applicationPhases, nsn)
+	} else {
+		applicationPhases[nsn] = phase
+	}
+	counts := make(map[string]int)
+	for _, item := range applicationPhases {
+		counts[item]++
+		knownApplicationPhases[item] = true
+	}
+	// 逐个设置指标而不是先Reset, 避免采集时看到空的application_status
+	for item := range knownApplicationPhases {
+		applicationStatus.WithLabelValues(item).Set(float64(counts[item]))
+	}
+}
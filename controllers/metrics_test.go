@@ -0,0 +1,71 @@
+/**
+ * 功能描述: 验证应用和module运行状态的监控指标
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestRecordApplicationMetrics(t *testing.T) {
+	app := newTestApplication(newTestModule("web"), newTestModule("api"))
+	app.Namespace = "metrics"
+	app.Status.Status = "Starting"
+	app.Status.TotalModuleNumber = 2
+	app.Status.StartingModuleNumber = 2
+	// 其他测试中的应用也会计入各状态的数量, 只比较变化量
+	phaseCount := func(phase string) float64 {
+		return testutil.ToFloat64(applicationStatus.WithLabelValues(phase))
+	}
+	starting, running := phaseCount("Starting"), phaseCount("Running")
+	recordApplicationMetrics(app)
+
+	if value := testutil.ToFloat64(applicationModulesTotal.WithLabelValues("metrics", "wsgw")); value != 2 {
+		t.Errorf("expected 2 modules, got %v", value)
+	}
+	if value := testutil.ToFloat64(applicationModulesStarting.WithLabelValues("metrics", "wsgw")); value != 2 {
+		t.Errorf("expected 2 starting modules, got %v", value)
+	}
+	if value := phaseCount("Starting"); value != starting+1 {
+		t.Errorf("expected %v starting apps, got %v", starting+1, value)
+	}
+
+	app.Status.Status = "Running"
+	app.Status.StartingModuleNumber = 0
+	app.Status.RunningModuleNumber = 2
+	recordApplicationMetrics(app)
+	if value := testutil.ToFloat64(applicationModulesRunning.WithLabelValues("metrics", "wsgw")); value != 2 {
+		t.Errorf("expected 2 running modules, got %v", value)
+	}
+	if value := phaseCount("Starting"); value != starting {
+		t.Errorf("expected %v starting apps, got %v", starting, value)
+	}
+	if value := phaseCount("Running"); value != running+1 {
+		t.Errorf("expected %v running apps, got %v", running+1, value)
+	}
+
+	forgetApplicationMetrics(types.NamespacedName{Namespace: "metrics", Name: "wsgw"})
+	if value := phaseCount("Running"); value != running {
+		t.Errorf("expected %v running apps after deletion, got %v", running, value)
+	}
+}
+
+func TestApplicationPhaseKeepsDroppedPhases(t *testing.T) {
+	nsn := types.NamespacedName{Namespace: "metrics", Name: "dropped"}
+	setApplicationPhase(nsn, "Dropped")
+	setApplicationPhase(nsn, "")
+	// 没有应用处于的状态保留为0, 而不是从指标中消失
+	applicationPhasesLock.Lock()
+	known := knownApplicationPhases["Dropped"]
+	applicationPhasesLock.Unlock()
+	if !known {
+		t.Fatalf("expected Dropped to be a known phase")
+	}
+	if value := testutil.ToFloat64(applicationStatus.WithLabelValues("Dropped")); value != 0 {
+		t.Errorf("expected no dropped app, got %v", value)
+	}
+}
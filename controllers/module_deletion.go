@@ -0,0 +1,125 @@
+/**
+ * 功能描述: 从spec中移除的module的删除保护和延迟删除
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"time"
+)
+
+// 处理从spec中移除的module对应的deployment. 需要保护或延迟删除时缩容到0并返回待删除状态, 返回nil时可以直接删除
+func (r *ApplicationReconciler) markPendingDeletion(ctx context.Context, app *appv1.Application, deploy *v1.Deployment) (*appv1.ModuleStatus, error) {
+	protected := deploy.Annotations[applabels.DeletionProtectionAnnotation] == "true"
+	gracePeriod := time.Duration(0)
+	if app.Spec.ModuleDeletionGracePeriodSeconds != nil {
+		gracePeriod = time.Duration(*app.Spec.ModuleDeletionGracePeriodSeconds) * time.Second
+	}
+	if !protected && gracePeriod <= 0 || deploy.Annotations[applabels.ConfirmDeletionAnnotation] == "true" {
+		return nil, nil
+	}
+
+	since, err := time.Parse(time.RFC3339, deploy.Annotations[applabels.PendingDeletionAnnotation])
+	if err != nil {
+		// 首次移除, 缩容到0并记录开始时间
+		since = time.Now()
+		if deploy.Annotations == nil {
+			deploy.Annotations = make(map[string]string)
+		}
+		deploy.Annotations[applabels.PendingDeletionAnnotation] = since.UTC().Format(time.RFC3339)
+		replicas := int32(0)
+		deploy.Spec.Replicas = &replicas
+		if err := r.Update(ctx, deploy); err != nil {
+			log.Error(err, "failed to scale down the removed deployment.", "namespace", deploy.Namespace, "name", deploy.Name)
+			return nil, err
+		}
+		log.Info("scaled down the removed deployment, waiting for deletion.", "namespace", deploy.Namespace, "name", deploy.Name)
+		r.recordModuleEvent(app, moduleNameOf(deploy), corev1.EventTypeNormal, ReasonModulePendingDeletion, "scaled deployment %s to zero, waiting for deletion", deploy.Name)
+	}
+
+	status := &appv1.ModuleStatus{Name: moduleNameOf(deploy), Phase: appv1.ModulePhasePendingDeletion, ResourceName: deploy.Name}
+	if !protected {
+		deleteAfter := since.Add(gracePeriod)
+		if !time.Now().Before(deleteAfter) {
+			return nil, nil
+		}
+		status.DeleteAfter = &metav1.Time{Time: deleteAfter}
+	}
+	return status, nil
+}
+
+// 重新加入spec的module取消待删除, 恢复为spec中的定义
+func cancelPendingDeletion(deploy *v1.Deployment) bool {
+	if _, isExist := deploy.Annotations[applabels.PendingDeletionAnnotation]; !isExist {
+		return false
+	}
+	delete(deploy.Annotations, applabels.PendingDeletionAnnotation)
+	delete(deploy.Annotations, applabels.ConfirmDeletionAnnotation)
+	return true
+}
+
+// 将deployment的删除保护注解同步为期望值, 返回是否发生了变更
+func syncDeletionProtection(deploy, found *v1.Deployment) bool {
+	value, isExist := deploy.Annotations[applabels.DeletionProtectionAnnotation]
+	if found.Annotations[applabels.DeletionProtectionAnnotation] == value {
+		return false
+	}
+	if !isExist {
+		delete(found.Annotations, applabels.DeletionProtectionAnnotation)
+		return true
+	}
+	if found.Annotations == nil {
+		found.Annotations = make(map[string]string)
+	}
+	found.Annotations[applabels.DeletionProtectionAnnotation] = value
+	return true
+}
+
+// 用本次计算的待删除module替换状态中原有的待删除module, 返回是否发生了变更
+func setPendingDeletionModules(status *appv1.ApplicationStatus, pending []appv1.ModuleStatus) bool {
+	modules := make([]appv1.ModuleStatus, 0, len(status.Modules)+len(pending))
+	old := make(map[string]appv1.ModuleStatus)
+	for _, module := range status.Modules {
+		if module.Phase == appv1.ModulePhasePendingDeletion {
+			old[module.Name] = module
+			continue
+		}
+		modules = append(modules, module)
+	}
+	changed := len(old) != len(pending)
+	for _, module := range pending {
+		if found, isExist := old[module.Name]; !isExist || !found.DeleteAfter.Equal(module.DeleteAfter) {
+			changed = true
+		}
+		modules = append(modules, module)
+	}
+	if len(modules) == 0 {
+		modules = nil
+	}
+	status.Modules = modules
+	return changed
+}
+
+// 距离最近一个待删除module的删除时间, 没有需要定时删除的module时返回0
+func nextModuleDeletion(status *appv1.ApplicationStatus) time.Duration {
+	next := time.Duration(0)
+	for _, module := range status.Modules {
+		if module.Phase != appv1.ModulePhasePendingDeletion || module.DeleteAfter == nil {
+			continue
+		}
+		after := time.Until(module.DeleteAfter.Time)
+		if after <= 0 {
+			after = time.Second
+		}
+		if next == 0 || after < next {
+			next = after
+		}
+	}
+	return next
+}
@@ -0,0 +1,123 @@
+/**
+ * 功能描述: 验证module的删除保护和延迟删除
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// 创建web和api两个module后从spec中移除api
+func newRemovedModuleReconciler(t *testing.T, app *appv1.Application) *ApplicationReconciler {
+	objs := append(newTestProxyConfigMaps(nil), app)
+	r, _ := newTestReconciler(t, objs...)
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	app.Spec.Modules = app.Spec.Modules[:1]
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	return r
+}
+
+func getTestDeployment(r *ApplicationReconciler, name string) (*appsv1.Deployment, error) {
+	deploy := &appsv1.Deployment{}
+	err := r.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: name}, deploy)
+	return deploy, err
+}
+
+func TestRemovedModuleWaitsForGracePeriod(t *testing.T) {
+	app := newTestApplication(newTestModule("web"), newTestModule("api"))
+	gracePeriod := int64(3600)
+	app.Spec.ModuleDeletionGracePeriodSeconds = &gracePeriod
+	r := newRemovedModuleReconciler(t, app)
+
+	deploy, err := getTestDeployment(r, "api")
+	if err != nil {
+		t.Fatalf("expected removed deployment to be kept: %v", err)
+	}
+	if *deploy.Spec.Replicas != 0 {
+		t.Errorf("expected removed deployment to be scaled to zero, got %d", *deploy.Spec.Replicas)
+	}
+	if len(app.Status.Modules) != 1 || app.Status.Modules[0].Phase != appv1.ModulePhasePendingDeletion || app.Status.Modules[0].DeleteAfter == nil {
+		t.Fatalf("expected pending deletion module in status, got %v", app.Status.Modules)
+	}
+	if after := nextModuleDeletion(&app.Status); after <= 0 || after > time.Hour {
+		t.Errorf("unexpected requeue after %v", after)
+	}
+
+	// 超过等待时间后删除
+	deploy.Annotations[applabels.PendingDeletionAnnotation] = time.Now().Add(-2 * time.Hour).UTC().Format(time.RFC3339)
+	if err := r.Update(context.TODO(), deploy); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	if _, err := getTestDeployment(r, "api"); err == nil {
+		t.Errorf("expected deployment to be deleted after grace period")
+	}
+	if len(app.Status.Modules) != 0 {
+		t.Errorf("expected pending deletion module to be removed from status, got %v", app.Status.Modules)
+	}
+}
+
+func TestProtectedModuleWaitsForConfirmation(t *testing.T) {
+	protected := newTestModule("api")
+	protected.DeletionProtection = true
+	app := newTestApplication(newTestModule("web"), protected)
+	r := newRemovedModuleReconciler(t, app)
+
+	deploy, err := getTestDeployment(r, "api")
+	if err != nil {
+		t.Fatalf("expected protected deployment to be kept: %v", err)
+	}
+	if len(app.Status.Modules) != 1 || app.Status.Modules[0].DeleteAfter != nil {
+		t.Fatalf("expected pending deletion module without deadline, got %v", app.Status.Modules)
+	}
+
+	deploy.Annotations[applabels.ConfirmDeletionAnnotation] = "true"
+	if err := r.Update(context.TODO(), deploy); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	if _, err := getTestDeployment(r, "api"); err == nil {
+		t.Errorf("expected deployment to be deleted after confirmation")
+	}
+}
+
+func TestReaddedModuleCancelsPendingDeletion(t *testing.T) {
+	protected := newTestModule("api")
+	protected.DeletionProtection = true
+	app := newTestApplication(newTestModule("web"), protected)
+	r := newRemovedModuleReconciler(t, app)
+
+	app.Spec.Modules = append(app.Spec.Modules, protected)
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	deploy, err := getTestDeployment(r, "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *deploy.Spec.Replicas != 1 || deploy.Annotations[applabels.PendingDeletionAnnotation] != "" {
+		t.Errorf("expected deployment to be restored, got replicas %d annotations %v", *deploy.Spec.Replicas, deploy.Annotations)
+	}
+	if *app.Spec.Modules[1].Template.Replicas != 1 {
+		t.Errorf("expected replicas of the spec not to be overwritten")
+	}
+	if len(app.Status.Modules) != 0 {
+		t.Errorf("expected pending deletion module to be removed from status, got %v", app.Status.Modules)
+	}
+}
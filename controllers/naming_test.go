@@ -0,0 +1,95 @@
+/**
+ * 功能描述: 验证带应用前缀的module资源命名方式
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestAppModuleNamingScheme(t *testing.T) {
+	app := newTestApplication(newTestModule("web", appv1.Proxy{Protocol: "tcp", Port: 80, TargetPort: 9098}))
+	app.Spec.NamingScheme = appv1.NamingSchemeAppModule
+	objs := append(newTestProxyConfigMaps(nil), app)
+	r, _ := newTestReconciler(t, objs...)
+
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	if err := r.reconcileSvc(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile svc: %v", err)
+	}
+	if err := r.reconcileProxy(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile proxy: %v", err)
+	}
+	if err := r.reconcileStatus(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile status: %v", err)
+	}
+
+	deploy, err := getTestDeployment(r, "wsgw-web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deploy.Spec.Selector.MatchLabels[applabels.ServiceSelector] != "wsgw-web" || deploy.Spec.Template.Labels[applabels.ServiceSelector] != "wsgw-web" {
+		t.Errorf("expected name label to be the resource name, got selector %v labels %v", deploy.Spec.Selector.MatchLabels, deploy.Spec.Template.Labels)
+	}
+	svc := &corev1.Service{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "wsgw-web"}, svc); err != nil {
+		t.Fatal(err)
+	}
+	if svc.Spec.Selector[applabels.ServiceSelector] != "wsgw-web" {
+		t.Errorf("unexpected svc selector %v", svc.Spec.Selector)
+	}
+	if value := getTCPConfigMap(t, r).Data["9098"]; value != "default/wsgw-web:80" {
+		t.Errorf("unexpected proxy rule %q", value)
+	}
+	if len(app.Status.Modules) != 1 || app.Status.Modules[0].ResourceName != "wsgw-web" {
+		t.Errorf("expected resource name in status, got %v", app.Status.Modules)
+	}
+}
+
+func TestChangeNamingSchemeReplacesDeployment(t *testing.T) {
+	app := newTestApplication(newTestModule("web"))
+	r, _ := newTestReconciler(t, append(newTestProxyConfigMaps(nil), app)...)
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+
+	app.Spec.NamingScheme = appv1.NamingSchemeAppModule
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	// 新deployment可用前保留原deployment
+	if _, err := getTestDeployment(r, "web"); err != nil {
+		t.Fatalf("expected previous deployment to be kept: %v", err)
+	}
+	if renaming, err := r.isRenaming(context.TODO(), app, &app.Spec.Modules[0]); err != nil || !renaming {
+		t.Errorf("expected module to be renaming, got %v %v", renaming, err)
+	}
+
+	deploy, err := getTestDeployment(r, "wsgw-web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deploy.Status.UpdatedReplicas = 1
+	deploy.Status.AvailableReplicas = 1
+	if err := r.Status().Update(context.TODO(), deploy); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reconcileSvc(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile svc: %v", err)
+	}
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	if _, err := getTestDeployment(r, "web"); err == nil {
+		t.Errorf("expected previous deployment to be deleted")
+	}
+}
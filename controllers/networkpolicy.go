@@ -0,0 +1,171 @@
+/**
+ * 功能描述: 根据module的访问模式对NetworkPolicy进行调谐
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	"fmt"
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// 对module对应的NetworkPolicy进行调谐
+func (r *ApplicationReconciler) reconcileNetworkPolicy(ctx context.Context, app *appv1.Application) error {
+	for i := range app.Spec.Modules {
+		module := &app.Spec.Modules[i]
+
+		// 根据模块名称查找集群中对应的deployment
+		deploy := &v1.Deployment{}
+		name := app.ModuleResourceName(module.Name)
+		err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: app.Namespace}, deploy)
+		if err != nil && apierrs.IsNotFound(err) {
+			log.Info("the deployment was not created. continue...", "namespace", app.Namespace, "name", name)
+			r.recordModuleWaiting(app, module.Name, "waiting for deployment to create network policy")
+			continue
+		} else if err != nil {
+			log.Error(err, "failed to get deploy for network policy reconcile.", "namespace", app.Namespace, "name", name)
+			return err
+		}
+
+		// 未被接管的deployment不生成network policy
+		if !metav1.IsControlledBy(deploy, app) {
+			continue
+		}
+
+		specPolicy := makeNetworkPolicyFromDeploy(module.AccessMode, deploy)
+		foundPolicy := &networkingv1.NetworkPolicy{}
+		err = r.Get(ctx, types.NamespacedName{Namespace: deploy.Namespace, Name: deploy.Name}, foundPolicy)
+		if err != nil && !apierrs.IsNotFound(err) {
+			log.Error(err, "failed to get network policy.", "namespace", deploy.Namespace, "name", deploy.Name)
+			return err
+		}
+		isFound := err == nil
+
+		// 未指定访问模式时不做隔离, 删除已经生成的NetworkPolicy
+		if specPolicy == nil {
+			if isFound && metav1.IsControlledBy(foundPolicy, app) {
+				if err := r.Delete(ctx, foundPolicy); err != nil {
+					log.Error(err, "failed to delete the no use network policy.", "namespace", deploy.Namespace, "name", deploy.Name)
+					return err
+				}
+				log.Info("delete the no use network policy.", "namespace", deploy.Namespace, "name", deploy.Name)
+				r.recordModuleEvent(app, module.Name, corev1.EventTypeNormal, ReasonNetworkPolicyUpdated, "deleted network policy since access mode is not set")
+			}
+			continue
+		}
+
+		// 同名的NetworkPolicy不是当前应用生成的, 不覆盖用户自己创建的策略. 同一策略只记录一次事件
+		skippedKey := fmt.Sprintf("%s/%s/networkpolicy/%s", app.Namespace, app.Name, module.Name)
+		if isFound && !metav1.IsControlledBy(foundPolicy, app) {
+			if r.recorded.changed(skippedKey, "skipped/"+string(foundPolicy.UID)) {
+				r.recordModuleEvent(app, module.Name, corev1.EventTypeWarning, ReasonNetworkPolicySkipped, "network policy %s already exists and is not controlled by the application", foundPolicy.Name)
+			}
+			continue
+		}
+		r.recorded.changed(skippedKey, "")
+
+		if err := controllerutil.SetControllerReference(app, specPolicy, r.Scheme); err != nil {
+			log.Error(err, "failed to set Owner reference for network policy", "moduleName", module.Name)
+			return err
+		}
+		if !isFound {
+			log.Info("the network policy is not found and create new one.", "namespace", deploy.Namespace, "name", deploy.Name)
+			if err := r.Create(ctx, specPolicy); err != nil {
+				log.Error(err, "failed to create network policy.", "namespace", deploy.Namespace, "name", deploy.Name)
+				return err
+			}
+			r.recordModuleEvent(app, module.Name, corev1.EventTypeNormal, ReasonNetworkPolicyUpdated, "created network policy for access mode %s", module.AccessMode)
+		} else if !reflect.DeepEqual(foundPolicy.Spec, specPolicy.Spec) || !reflect.DeepEqual(foundPolicy.Labels, specPolicy.Labels) {
+			foundPolicy.Labels = specPolicy.Labels
+			foundPolicy.Spec = specPolicy.Spec
+			if err := r.Update(ctx, foundPolicy); err != nil {
+				log.Error(err, "failed to update network policy.", "namespace", deploy.Namespace, "name", deploy.Name)
+				return err
+			}
+			r.recordModuleEvent(app, module.Name, corev1.EventTypeNormal, ReasonNetworkPolicyUpdated, "updated network policy for access mode %s", module.AccessMode)
+		}
+	}
+	return nil
+}
+
+// 删除module对应的NetworkPolicy, 只删除由当前应用生成的
+func (r *ApplicationReconciler) cleanUpNetworkPolicy(ctx context.Context, app *appv1.Application, nsn types.NamespacedName) error {
+	policy := &networkingv1.NetworkPolicy{}
+	err := r.Get(ctx, nsn, policy)
+	if err != nil && apierrs.IsNotFound(err) {
+		return nil
+	} else if err != nil {
+		log.Error(err, "failed to get network policy.", "namespace", nsn.Namespace, "name", nsn.Name)
+		return err
+	}
+	if !metav1.IsControlledBy(policy, app) {
+		return nil
+	}
+	if err := r.Delete(ctx, policy); err != nil && !apierrs.IsNotFound(err) {
+		log.Error(err, "failed to delete network policy.", "namespace", nsn.Namespace, "name", nsn.Name)
+		return err
+	}
+	return nil
+}
+
+// 根据访问模式生成NetworkPolicy, 通过deployment中pod的应用和模块标签选择pod
+func makeNetworkPolicyFromDeploy(accessMode string, deploy *v1.Deployment) *networkingv1.NetworkPolicy {
+	podLabels := deploy.Spec.Template.Labels
+	var ingress []networkingv1.NetworkPolicyIngressRule
+	switch accessMode {
+	case appv1.AccessModePrivate:
+		// 只允许同一应用的pod访问
+		ingress = []networkingv1.NetworkPolicyIngressRule{{
+			From: []networkingv1.NetworkPolicyPeer{{
+				PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{applabels.AppName: podLabels[applabels.AppName]}},
+			}},
+		}}
+	case appv1.AccessModeNamespace:
+		// 空的podSelector表示同一namespace中的所有pod
+		ingress = []networkingv1.NetworkPolicyIngressRule{{
+			From: []networkingv1.NetworkPolicyPeer{{
+				PodSelector: &metav1.LabelSelector{},
+			}},
+		}}
+	case appv1.AccessModeCluster:
+		// 空的namespaceSelector表示集群中的所有pod
+		ingress = []networkingv1.NetworkPolicyIngressRule{{
+			From: []networkingv1.NetworkPolicyPeer{{
+				NamespaceSelector: &metav1.LabelSelector{},
+			}},
+		}}
+	case appv1.AccessModeOutside:
+		// 不限制来源, 允许ingress proxy以及集群外部访问
+		ingress = []networkingv1.NetworkPolicyIngressRule{{}}
+	default:
+		return nil
+	}
+
+	return &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      deploy.Name,
+			Namespace: deploy.Namespace,
+			Labels:    copyLabels(deploy.Labels),
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					applabels.AppName:    podLabels[applabels.AppName],
+					applabels.ModuleName: podLabels[applabels.ModuleName],
+				},
+			},
+			Ingress:     ingress,
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress},
+		},
+	}
+}
@@ -0,0 +1,138 @@
+/**
+ * 功能描述: 验证各访问模式生成的NetworkPolicy
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestMakeNetworkPolicyForAccessModes(t *testing.T) {
+	app := newTestApplication(newTestModule("web"))
+	// 显示名称与应用名称不同, 选择器只能使用应用名称
+	app.Spec.DisplayName = "网关"
+	r, _ := newTestReconciler(t, app)
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	deploy, err := getTestDeployment(r, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	appSelector := &metav1.LabelSelector{MatchLabels: map[string]string{applabels.AppName: "wsgw"}}
+	cases := []struct {
+		accessMode string
+		expected   []networkingv1.NetworkPolicyIngressRule
+	}{
+		{accessMode: appv1.AccessModePrivate, expected: []networkingv1.NetworkPolicyIngressRule{{From: []networkingv1.NetworkPolicyPeer{{PodSelector: appSelector}}}}},
+		{accessMode: appv1.AccessModeNamespace, expected: []networkingv1.NetworkPolicyIngressRule{{From: []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}}}}},
+		{accessMode: appv1.AccessModeCluster, expected: []networkingv1.NetworkPolicyIngressRule{{From: []networkingv1.NetworkPolicyPeer{{NamespaceSelector: &metav1.LabelSelector{}}}}}},
+		{accessMode: appv1.AccessModeOutside, expected: []networkingv1.NetworkPolicyIngressRule{{}}},
+	}
+	for _, c := range cases {
+		policy := makeNetworkPolicyFromDeploy(c.accessMode, deploy)
+		if policy == nil {
+			t.Errorf("expected network policy for access mode %s", c.accessMode)
+			continue
+		}
+		if !reflect.DeepEqual(policy.Spec.Ingress, c.expected) {
+			t.Errorf("unexpected ingress rules for access mode %s: %v", c.accessMode, policy.Spec.Ingress)
+		}
+		selector := policy.Spec.PodSelector.MatchLabels
+		if selector[applabels.AppName] != "wsgw" || selector[applabels.ModuleName] != "web" {
+			t.Errorf("unexpected pod selector for access mode %s: %v", c.accessMode, selector)
+		}
+		if policy.Name != deploy.Name || policy.Namespace != deploy.Namespace {
+			t.Errorf("unexpected network policy name %s/%s", policy.Namespace, policy.Name)
+		}
+	}
+	if policy := makeNetworkPolicyFromDeploy("", deploy); policy != nil {
+		t.Errorf("expected no network policy without access mode, got %v", policy)
+	}
+}
+
+func TestReconcileNetworkPolicyFollowsAccessMode(t *testing.T) {
+	app := newTestApplication(newTestModule("web"))
+	r, _ := newTestReconciler(t, app)
+	reconcile := func() {
+		t.Helper()
+		if err := r.reconcileInstance(context.TODO(), app); err != nil {
+			t.Fatalf("reconcile instance: %v", err)
+		}
+		if err := r.reconcileNetworkPolicy(context.TODO(), app); err != nil {
+			t.Fatalf("reconcile network policy: %v", err)
+		}
+	}
+	policy := &networkingv1.NetworkPolicy{}
+	nsn := types.NamespacedName{Namespace: "default", Name: "web"}
+
+	reconcile()
+	if err := r.Get(context.TODO(), nsn, policy); err != nil {
+		t.Fatal(err)
+	}
+	if !metav1.IsControlledBy(policy, app) {
+		t.Errorf("expected network policy to be controlled by the app")
+	}
+
+	app.Spec.Modules[0].AccessMode = appv1.AccessModePrivate
+	reconcile()
+	if err := r.Get(context.TODO(), nsn, policy); err != nil {
+		t.Fatal(err)
+	}
+	if len(policy.Spec.Ingress) != 1 || len(policy.Spec.Ingress[0].From) != 1 || policy.Spec.Ingress[0].From[0].PodSelector == nil {
+		t.Errorf("expected network policy to be updated to private, got %v", policy.Spec.Ingress)
+	}
+
+	app.Spec.Modules[0].AccessMode = ""
+	reconcile()
+	if err := r.Get(context.TODO(), nsn, policy); err == nil {
+		t.Errorf("expected network policy to be deleted without access mode")
+	}
+}
+
+func TestReconcileNetworkPolicySkipsPolicyNotControlledByApp(t *testing.T) {
+	// 用户自己创建的与module同名的NetworkPolicy
+	userPolicy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", Labels: map[string]string{"team": "ops"}},
+		Spec:       networkingv1.NetworkPolicySpec{PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress}},
+	}
+	app := newTestApplication(newTestModule("web"))
+	app.Spec.Modules[0].AccessMode = appv1.AccessModePrivate
+	r, recorder := newTestReconciler(t, app, userPolicy)
+	for i := 0; i < 2; i++ {
+		if err := r.reconcileInstance(context.TODO(), app); err != nil {
+			t.Fatalf("reconcile instance: %v", err)
+		}
+		if err := r.reconcileNetworkPolicy(context.TODO(), app); err != nil {
+			t.Fatalf("reconcile network policy: %v", err)
+		}
+	}
+
+	policy := &networkingv1.NetworkPolicy{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "web"}, policy); err != nil {
+		t.Fatal(err)
+	}
+	if policy.Labels["team"] != "ops" || len(policy.Spec.Ingress) != 0 || metav1.GetControllerOf(policy) != nil {
+		t.Errorf("expected network policy not controlled by the app to be kept, got %v", policy)
+	}
+	skipped := 0
+	for _, event := range drainEvents(recorder) {
+		if strings.Contains(event, ReasonNetworkPolicySkipped) {
+			skipped += 1
+		}
+	}
+	if skipped != 1 {
+		t.Errorf("expected one %s event, got %d", ReasonNetworkPolicySkipped, skipped)
+	}
+}
@@ -7,193 +7,301 @@ package controllers
 
 import (
 	"context"
-	"errors"
+	"encoding/json"
 	"fmt"
 	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
 	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strconv"
 	"strings"
+	"time"
 )
 
 var (
 	KubeSystemNamespace = "kube-system"
 	IngressTCPConfigMap = "tcp-services"
 	IngressUDPConfigMap = "udp-services"
+	// 多个worker同时修改ingress configmap时的冲突重试, 随机抖动避免同时重试再次冲突
+	proxyPatchBackoff = wait.Backoff{Steps: 10, Duration: 10 * time.Millisecond, Factor: 2.0, Jitter: 0.5, Cap: 2 * time.Second}
 )
 
-func (r *ApplicationReconciler) reconcileProxy(app *appv1.Application) error {
+// proxy规则的归属信息, 控制器只修改和删除归属于自己module的规则
+type ProxyOwner struct {
+	UID       types.UID `json:"uid,omitempty"`
+	Namespace string    `json:"namespace,omitempty"`
+	App       string    `json:"app,omitempty"`
+	Module    string    `json:"module,omitempty"`
+	UserID    string    `json:"userID"`
+}
+
+func newProxyOwner(app *appv1.Application, module string) ProxyOwner {
+	return ProxyOwner{
+		UID:       app.UID,
+		Namespace: app.Namespace,
+		App:       app.Name,
+		Module:    module,
+		UserID:    strconv.Itoa(app.Spec.UserID),
+	}
+}
+
+// 判断规则是否属于同一个应用的同一个module
+func (o ProxyOwner) owns(other ProxyOwner) bool {
+	return o.UID != "" && o.UID == other.UID && o.Module == other.Module
+}
+
+// 端口已被其他服务占用
+type proxyConflictError struct {
+	protocol string
+	port     string
+	usedBy   string
+}
+
+func (e *proxyConflictError) Error() string {
+	return fmt.Sprintf("the %s port %s is already used.", e.protocol, e.port)
+}
+
+func (r *ApplicationReconciler) reconcileProxy(ctx context.Context, app *appv1.Application) error {
 	for i := range app.Spec.Modules {
 		module := &app.Spec.Modules[i]
 
-		// 判断是否需要集群外部访问
-		if module.AccessMode != "outside" {
-			// 不需要外部访问
-			module.Proxies = nil
-		}
-
-		tcpProxyMap := make(map[string]string)
-		udpProxyMap := make(map[string]string)
-		// 根据预定义内容生成期望的tcp/udp规则
-		if len(module.Proxies) > 0 {
-			for _, proxy := range module.Proxies {
-				if proxy.Protocol == "tcp" || proxy.Protocol == "TCP" {
-					tcpProxyMap[fmt.Sprintf("%d", proxy.TargetPort)] = fmt.Sprintf("%s/%s:%d", app.Namespace, module.Name, proxy.Port)
-				} else if proxy.Protocol == "udp" || proxy.Protocol == "UDP" {
-					udpProxyMap[fmt.Sprintf("%d", proxy.TargetPort)] = fmt.Sprintf("%s/%s:%d", app.Namespace, module.Name, proxy.Port)
-				}
-			}
-		}
-
-		// get tcp and udp ingress configmap
-		tcpConfigMap := &corev1.ConfigMap{}
-		err := r.Get(context.TODO(), types.NamespacedName{Namespace: KubeSystemNamespace, Name: IngressTCPConfigMap}, tcpConfigMap)
+		// 重命名中的module在新deployment可用后才迁移proxy规则, 见cleanUpDeployment
+		renaming, err := r.isRenaming(ctx, app, module)
 		if err != nil {
-			log.Error(err, "failed to get ingress config map for tcp")
 			return err
 		}
-		if tcpConfigMap.Data == nil {
-			tcpConfigMap.Data = make(map[string]string)
+		if renaming {
+			continue
 		}
-
-		udpConfigMap := &corev1.ConfigMap{}
-		err = r.Get(context.TODO(), types.NamespacedName{Namespace: KubeSystemNamespace, Name: IngressUDPConfigMap}, udpConfigMap)
-		if err != nil {
-			log.Error(err, "failed to get ingress config map for udp")
+		if err := r.reconcileModuleProxy(ctx, app, module); err != nil {
 			return err
 		}
-		if udpConfigMap.Data == nil {
-			udpConfigMap.Data = make(map[string]string)
-		}
+	}
 
-		// 获取 tcp config map 中本module中的规则
-		tcpRules := GetProxyRulesForNameSpaceName(types.NamespacedName{Name: module.Name, Namespace: app.Namespace}, tcpConfigMap)
-		// 比对已有规则和期望规则是否一致,不一致清空已有规则,重新添加期望规则
-		if !reflect.DeepEqual(tcpRules, tcpProxyMap) {
-			// 如果proxy 发生变化,清空原有配置
-			log.Info("the proxy has changed, update ingress config map for tcp.")
-			for key, _ := range tcpRules {
-				delete(tcpConfigMap.Data, key)
-			}
+	return nil
+}
 
-			// 检查端口是否被占用
-			for key := range tcpProxyMap {
-				_, isExist := tcpConfigMap.Data[key]
-				if isExist {
-					log.Error(nil, "th tcp port is already used.", "protocol", "tcp", "port", key)
-					return errors.New(fmt.Sprintf("the tcp port %s is already used.", key))
-				}
-			}
+// 将module的tcp/udp规则更新为期望规则, 归属于formerOwners的规则转移给module
+func (r *ApplicationReconciler) reconcileModuleProxy(ctx context.Context, app *appv1.Application, module *appv1.Module, formerOwners ...ProxyOwner) error {
+	// 根据预定义内容生成期望的tcp/udp规则
+	tcpProxyMap, udpProxyMap := makeModuleProxyRules(app, module)
+	if err := r.reconcileProxyRules(ctx, app, module.Name, "tcp", IngressTCPConfigMap, tcpProxyMap, formerOwners...); err != nil {
+		return err
+	}
+	return r.reconcileProxyRules(ctx, app, module.Name, "udp", IngressUDPConfigMap, udpProxyMap, formerOwners...)
+}
+
+// 更新单个协议的proxy规则, 并记录对应的事件和监控指标
+func (r *ApplicationReconciler) reconcileProxyRules(ctx context.Context, app *appv1.Application, module, protocol, configMapName string, specRules map[string]string, formerOwners ...ProxyOwner) error {
+	updated, err := r.patchProxyRules(ctx, configMapName, protocol, newProxyOwner(app, module), specRules, formerOwners...)
+	if conflict, ok := err.(*proxyConflictError); ok {
+		log.Error(nil, "the port is already used.", "protocol", protocol, "port", conflict.port)
+		proxyPortConflictsTotal.WithLabelValues(app.Namespace, app.Name, protocol).Inc()
+		r.recordModuleEvent(app, module, corev1.EventTypeWarning, ReasonProxyConflict, "%s port %s is already used by %s", protocol, conflict.port, conflict.usedBy)
+		return err
+	} else if err != nil {
+		log.Error(err, "failed to update ingress config map.", "protocol", protocol)
+		return err
+	}
+	if updated {
+		r.recordModuleEvent(app, module, corev1.EventTypeNormal, ReasonProxyUpdated, "updated ingress %s proxy rules", protocol)
+		log.Info("successful update ingress config map.", "protocol", protocol)
+	}
+	return nil
+}
+
+func (r *ApplicationReconciler) cleanUpProxy(ctx context.Context, app *appv1.Application, module string) error {
+	// 清空module在tcp/udp configmap中由控制器创建的规则
+	owner := newProxyOwner(app, module)
+	if _, err := r.patchProxyRules(ctx, IngressTCPConfigMap, "tcp", owner, nil); err != nil {
+		log.Error(err, "failed to update ingress tcp config map.")
+		return err
+	}
+	if _, err := r.patchProxyRules(ctx, IngressUDPConfigMap, "udp", owner, nil); err != nil {
+		log.Error(err, "failed to update ingress udp config map.")
+		return err
+	}
+	return nil
+}
 
-			// 添加指定规则到configmap
-			if len(tcpProxyMap) > 0 {
-				for key, value := range tcpProxyMap {
-					tcpConfigMap.Data[key] = value
-				}
+// 保留module在tcp/udp configmap中的规则, 只删除其归属记录, 之后控制器不再修改这些规则
+func (r *ApplicationReconciler) releaseProxy(ctx context.Context, app *appv1.Application, module string) error {
+	owner := newProxyOwner(app, module)
+	for protocol, configMapName := range map[string]string{"tcp": IngressTCPConfigMap, "udp": IngressUDPConfigMap} {
+		err := retry.RetryOnConflict(proxyPatchBackoff, func() error {
+			configMap := &corev1.ConfigMap{}
+			if err := r.apiReader().Get(ctx, types.NamespacedName{Namespace: KubeSystemNamespace, Name: configMapName}, configMap); err != nil {
+				return err
+			}
+			owners := getProxyOwners(configMap)
+			rules := getOwnedProxyRules(configMap, owners, owner)
+			if len(rules) == 0 {
+				return nil
 			}
-			// 更新保存config map
-			err = r.Update(context.TODO(), tcpConfigMap)
+			for key := range rules {
+				delete(owners, key)
+			}
+			patch, err := makeProxyRulesPatch(configMap.ResourceVersion, map[string]interface{}{}, owners)
 			if err != nil {
-				log.Error(err, "failed to update ingress tcp config map.")
 				return err
 			}
-			r.Recorder.Event(tcpConfigMap, "Normal", "SuccessfulUpdate", fmt.Sprintf("SuccessfulUpdate ingress tcp config map for moudle %s  in %s/%s", module.Name, app.Namespace, app.Spec.DisplayName))
+			return r.Patch(ctx, configMap, client.ConstantPatch(types.MergePatchType, patch))
+		})
+		if err != nil {
+			log.Error(err, "failed to release ingress config map.", "protocol", protocol)
+			return err
+		}
+	}
+	return nil
+}
 
-			log.Info("successful update ingress config map for tcp.")
+// 将module在ingress configmap中的规则更新为期望规则, 返回是否发生了变更.
+// 多个应用会同时修改同一个configmap, 使用带resourceVersion的merge patch只修改本module的端口,
+// 版本冲突时重新读取configmap并重试. 归属于formerOwners的规则视为本module的规则, 并转移给owner
+func (r *ApplicationReconciler) patchProxyRules(ctx context.Context, configMapName, protocol string, owner ProxyOwner, specRules map[string]string, formerOwners ...ProxyOwner) (bool, error) {
+	updated := false
+	err := retry.RetryOnConflict(proxyPatchBackoff, func() error {
+		updated = false
+		configMap := &corev1.ConfigMap{}
+		err := r.apiReader().Get(ctx, types.NamespacedName{Namespace: KubeSystemNamespace, Name: configMapName}, configMap)
+		if err != nil {
+			log.Error(err, "failed to get ingress config map.", "protocol", protocol)
+			return err
 		}
 
-		// 更新UDP端口代理
-		// 获取 udp config map 中本module中的规则
-		udpRules := GetProxyRulesForNameSpaceName(types.NamespacedName{Name: module.Name, Namespace: app.Namespace}, udpConfigMap)
-		if !reflect.DeepEqual(udpRules, udpProxyMap) {
-			// 如果proxy 发生变化,清空原有配置
-			log.Info("the proxy has changed, update ingress config map for udp.")
-			for key := range udpRules {
-				delete(udpConfigMap.Data, key)
+		// 比对已有规则和期望规则是否一致
+		owners := getProxyOwners(configMap)
+		rules := getOwnedProxyRules(configMap, owners, owner)
+		moved := false
+		for _, former := range formerOwners {
+			for key, value := range getOwnedProxyRules(configMap, owners, former) {
+				rules[key] = value
+				moved = true
 			}
+		}
+		if !moved && (len(rules) == 0 && len(specRules) == 0 || reflect.DeepEqual(rules, specRules)) {
+			return nil
+		}
 
-			// 检查端口被占用
-			for key := range udpProxyMap {
-				_, isExist := udpConfigMap.Data[key]
-				if isExist {
-					log.Error(nil, "the tcp port is already used.", "protocol", "udp", "port", key)
-					return errors.New(fmt.Sprintf("the udp port %s is already used.", key))
-				}
+		// 检查端口是否被其他服务占用, 没有归属记录且与期望规则完全一致的旧规则直接认领
+		for key, specValue := range specRules {
+			value, isExist := configMap.Data[key]
+			if _, isOwn := rules[key]; !isExist || isOwn {
+				continue
 			}
-
-			if len(udpProxyMap) > 0 {
-				for key, value := range udpProxyMap {
-					udpConfigMap.Data[key] = value
-				}
+			if _, hasOwner := owners[key]; hasOwner || value != specValue {
+				return &proxyConflictError{protocol: protocol, port: key, usedBy: value}
 			}
-			// 更新保存config map
-			err = r.Update(context.TODO(), udpConfigMap)
-			if err != nil {
-				log.Error(err, "failed to update ingress udp config map.")
-				r.Recorder.Event(udpConfigMap, "Normal", "SuccessfulUpdate", fmt.Sprintf("SuccessfulUpdate ingress udp config map for moudle %s  in %s/%s", module.Name, app.Namespace, app.Spec.DisplayName))
+		}
 
-				return err
+		// 只修改本module的端口, 删除的端口置为null
+		data := make(map[string]interface{})
+		for key := range rules {
+			if _, isExist := specRules[key]; !isExist {
+				data[key] = nil
+				delete(owners, key)
 			}
-			r.Recorder.Event(udpConfigMap, "Normal", "SuccessfulUpdate", fmt.Sprintf("SuccessfulUpdate ingress udp config map for moudle %s  in %s/%s", module.Name, app.Namespace, app.Spec.DisplayName))
-			log.Info("successful update ingress config map for udp.")
 		}
-
-	}
-
-	return nil
+		for key, value := range specRules {
+			data[key] = value
+			owners[key] = owner
+		}
+		patch, err := makeProxyRulesPatch(configMap.ResourceVersion, data, owners)
+		if err != nil {
+			return err
+		}
+		if err := r.Patch(ctx, configMap, client.ConstantPatch(types.MergePatchType, patch)); err != nil {
+			return err
+		}
+		updated = true
+		return nil
+	})
+	return updated, err
 }
 
-func (r *ApplicationReconciler) cleanUpProxy(deploy types.NamespacedName) error {
-	// 根据deploy获取当前的tcp/udp configmap
-	// 获取tcp configmap
-	tcpConfigMap := &corev1.ConfigMap{}
-	err := r.Get(context.TODO(), types.NamespacedName{Namespace: KubeSystemNamespace, Name: IngressTCPConfigMap}, tcpConfigMap)
-	if err != nil {
-		log.Error(err, "failed to get ingress config map for tcp")
-		return err
+// 生成ingress configmap的merge patch, 带上resourceVersion以便在并发修改时返回冲突
+func makeProxyRulesPatch(resourceVersion string, data map[string]interface{}, owners map[string]ProxyOwner) ([]byte, error) {
+	var ownersValue interface{}
+	if len(owners) > 0 {
+		value, err := json.Marshal(owners)
+		if err != nil {
+			return nil, err
+		}
+		ownersValue = string(value)
 	}
+	return json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"resourceVersion": resourceVersion,
+			"annotations":     map[string]interface{}{applabels.ProxyOwnersAnnotation: ownersValue},
+		},
+		"data": data,
+	})
+}
 
-	udpConfigMap := &corev1.ConfigMap{}
-	err = r.Get(context.TODO(), types.NamespacedName{Namespace: KubeSystemNamespace, Name: IngressUDPConfigMap}, udpConfigMap)
-	if err != nil {
-		log.Error(err, "failed to get ingress config map for tcp")
-		return err
+// 生成module期望的tcp/udp规则, 只有需要集群外部访问的module才配置proxy
+func makeModuleProxyRules(app *appv1.Application, module *appv1.Module) (tcpRules, udpRules map[string]string) {
+	proxies := module.Proxies
+	if module.AccessMode != appv1.AccessModeOutside {
+		// 不需要外部访问
+		proxies = nil
 	}
+	return makeProxyRules(app.Namespace, app.ModuleResourceName(module.Name), proxies)
+}
 
-	// 获取当前deploy的规则
-	tcpRules := GetProxyRulesForNameSpaceName(types.NamespacedName{Namespace: deploy.Namespace, Name: deploy.Name}, tcpConfigMap)
-	udpRules := GetProxyRulesForNameSpaceName(types.NamespacedName{Namespace: deploy.Namespace, Name: deploy.Name}, udpConfigMap)
-
-	// 清空规则并保存
-	if len(tcpRules) > 0 {
-		for key := range tcpRules {
-			delete(tcpConfigMap.Data, key)
+// 根据proxy设置生成ingress tcp/udp configmap中的规则, key为对外端口
+func makeProxyRules(namespace, name string, proxies []appv1.Proxy) (tcpRules, udpRules map[string]string) {
+	tcpRules = make(map[string]string)
+	udpRules = make(map[string]string)
+	for _, proxy := range proxies {
+		if proxy.Protocol == "tcp" || proxy.Protocol == "TCP" {
+			tcpRules[fmt.Sprintf("%d", proxy.TargetPort)] = makeProxyRuleValue(namespace, name, proxy)
+		} else if proxy.Protocol == "udp" || proxy.Protocol == "UDP" {
+			// udp不支持PROXY protocol
+			udpRules[fmt.Sprintf("%d", proxy.TargetPort)] = fmt.Sprintf("%s/%s:%d", namespace, name, proxy.Port)
 		}
 	}
-	if len(udpRules) > 0 {
-		for key := range udpRules {
-			delete(udpConfigMap.Data, key)
+	return
+}
+
+// 生成规则的值, 格式为 namespace/name:port[:decode[:encode]], decode和encode为PROXY时开启对应的PROXY protocol
+func makeProxyRuleValue(namespace, name string, proxy appv1.Proxy) string {
+	value := fmt.Sprintf("%s/%s:%d", namespace, name, proxy.Port)
+	if proxy.ProxyProtocolDecode || proxy.ProxyProtocolEncode {
+		decode := ""
+		if proxy.ProxyProtocolDecode {
+			decode = "PROXY"
 		}
+		value = value + ":" + decode
 	}
-	err = r.Update(context.TODO(), tcpConfigMap)
-	if err != nil {
-		log.Error(err, "failed to update ingress tcp config map.")
-		return err
+	if proxy.ProxyProtocolEncode {
+		value = value + ":PROXY"
 	}
-	err = r.Update(context.TODO(), udpConfigMap)
-	if err != nil {
-		log.Error(err, "failed to update ingress udp config map.")
-		return err
+	return value
+}
+
+// 获取configmap中归属于module的规则
+func getOwnedProxyRules(configMap *corev1.ConfigMap, owners map[string]ProxyOwner, owner ProxyOwner) map[string]string {
+	rules := make(map[string]string)
+	for key, value := range configMap.Data {
+		if found, isExist := owners[key]; isExist && owner.owns(found) {
+			rules[key] = value
+		}
 	}
-	return nil
+	return rules
 }
 
+// 按规则的值查找指向namespace/name服务的规则, 不区分规则是否由控制器创建
 func GetProxyRulesForNameSpaceName(nsn types.NamespacedName, confitMap *corev1.ConfigMap) (Rules map[string]string) {
 	Rules = make(map[string]string)
 	if confitMap == nil || confitMap.Data == nil {
 		return
 	}
 	for cmKey, cmValue := range confitMap.Data {
+		// 规则的值可能带有PROXY protocol后缀, 只比较第一段的服务名称
 		cmValuestrs := strings.Split(cmValue, ":")
 		namespaceName := types.NamespacedName{
 			Name:      nsn.Name,
@@ -205,3 +313,17 @@ func GetProxyRulesForNameSpaceName(nsn types.NamespacedName, confitMap *corev1.C
 	}
 	return
 }
+
+// 从configmap的注解中获取proxy规则的归属信息, 注解不存在或格式错误时返回空map
+func getProxyOwners(configMap *corev1.ConfigMap) map[string]ProxyOwner {
+	owners := make(map[string]ProxyOwner)
+	value, isExist := configMap.Annotations[applabels.ProxyOwnersAnnotation]
+	if !isExist {
+		return owners
+	}
+	if err := json.Unmarshal([]byte(value), &owners); err != nil {
+		log.Error(err, "failed to parse proxy owners annotation, reset it.", "namespace", configMap.Namespace, "name", configMap.Name)
+		return make(map[string]ProxyOwner)
+	}
+	return owners
+}
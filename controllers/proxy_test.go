@@ -0,0 +1,225 @@
+/**
+ * 功能描述: 在envtest中验证多个应用并发修改ingress configmap时不会丢失规则
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
+
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+)
+
+var _ = Describe("Proxy rules", func() {
+	const appNumber = 30
+
+	var r *ApplicationReconciler
+
+	BeforeEach(func() {
+		r = &ApplicationReconciler{
+			Client:   k8sClient,
+			Log:      log,
+			Scheme:   scheme.Scheme,
+			Recorder: record.NewFakeRecorder(appNumber * 10),
+		}
+		for _, name := range []string{IngressTCPConfigMap, IngressUDPConfigMap} {
+			configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: KubeSystemNamespace}}
+			err := k8sClient.Create(context.TODO(), configMap)
+			if err != nil && !apierrs.IsAlreadyExists(err) {
+				Expect(err).NotTo(HaveOccurred())
+			}
+		}
+	})
+
+	AfterEach(func() {
+		for _, name := range []string{IngressTCPConfigMap, IngressUDPConfigMap} {
+			configMap := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: KubeSystemNamespace}}
+			Expect(k8sClient.Delete(context.TODO(), configMap)).To(Succeed())
+		}
+	})
+
+	newProxyApp := func(i int) *appv1.Application {
+		app := newTestApplication(newTestModule("web",
+			appv1.Proxy{Protocol: "tcp", Port: 80, TargetPort: int32(10000 + i)},
+			appv1.Proxy{Protocol: "udp", Port: 53, TargetPort: int32(20000 + i)},
+		))
+		app.Namespace = fmt.Sprintf("ns-%d", i)
+		app.Name = fmt.Sprintf("app-%d", i)
+		app.UID = types.UID(fmt.Sprintf("uid-%d", i))
+		return app
+	}
+
+	getData := func(name string) map[string]string {
+		configMap := &corev1.ConfigMap{}
+		Expect(k8sClient.Get(context.TODO(), types.NamespacedName{Namespace: KubeSystemNamespace, Name: name}, configMap)).To(Succeed())
+		return configMap.Data
+	}
+
+	reconcileAll := func(f func(ctx context.Context, app *appv1.Application) error) {
+		var wg sync.WaitGroup
+		errs := make(chan error, appNumber)
+		for i := 0; i < appNumber; i++ {
+			wg.Add(1)
+			go func(app *appv1.Application) {
+				defer GinkgoRecover()
+				defer wg.Done()
+				errs <- f(context.TODO(), app)
+			}(newProxyApp(i))
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			Expect(err).NotTo(HaveOccurred())
+		}
+	}
+
+	It("should keep the rules of all applications reconciling concurrently", func() {
+		reconcileAll(r.reconcileProxy)
+
+		tcpData := getData(IngressTCPConfigMap)
+		udpData := getData(IngressUDPConfigMap)
+		Expect(tcpData).To(HaveLen(appNumber))
+		Expect(udpData).To(HaveLen(appNumber))
+		for i := 0; i < appNumber; i++ {
+			Expect(tcpData).To(HaveKeyWithValue(fmt.Sprintf("%d", 10000+i), fmt.Sprintf("ns-%d/web:80", i)))
+			Expect(udpData).To(HaveKeyWithValue(fmt.Sprintf("%d", 20000+i), fmt.Sprintf("ns-%d/web:53", i)))
+		}
+	})
+
+	It("should only remove the rules of the cleaned up module", func() {
+		reconcileAll(r.reconcileProxy)
+		reconcileAll(func(ctx context.Context, app *appv1.Application) error {
+			var i int
+			fmt.Sscanf(app.Name, "app-%d", &i)
+			if i%2 == 0 {
+				return nil
+			}
+			return r.cleanUpProxy(ctx, app, "web")
+		})
+
+		tcpData := getData(IngressTCPConfigMap)
+		Expect(tcpData).To(HaveLen(appNumber / 2))
+		for i := 0; i < appNumber; i += 2 {
+			Expect(tcpData).To(HaveKey(fmt.Sprintf("%d", 10000+i)))
+		}
+	})
+})
+
+func TestCleanUpProxyKeepsRulesNotCreatedByController(t *testing.T) {
+	app := newTestApplication(newTestModule("web", appv1.Proxy{Protocol: "tcp", Port: 80, TargetPort: 9098}))
+	// 其他工具创建的指向同名服务的规则
+	objs := append(newTestProxyConfigMaps(map[string]string{"9099": "default/web:80:PROXY"}), app)
+	r, _ := newTestReconciler(t, objs...)
+
+	if err := r.reconcileProxy(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile proxy: %v", err)
+	}
+	if err := r.cleanUpProxy(context.TODO(), app, "web"); err != nil {
+		t.Fatalf("clean up proxy: %v", err)
+	}
+
+	configMap := getTCPConfigMap(t, r)
+	if _, isExist := configMap.Data["9098"]; isExist {
+		t.Errorf("expected rule created by controller to be removed, got %v", configMap.Data)
+	}
+	if configMap.Data["9099"] != "default/web:80:PROXY" {
+		t.Errorf("expected foreign rule to be kept, got %v", configMap.Data)
+	}
+	if _, isExist := configMap.Annotations[applabels.ProxyOwnersAnnotation]; isExist {
+		t.Errorf("expected owners annotation to be removed, got %v", configMap.Annotations)
+	}
+}
+
+func TestReconcileProxyAdoptsMatchingLegacyRule(t *testing.T) {
+	app := newTestApplication(newTestModule("web", appv1.Proxy{Protocol: "tcp", Port: 80, TargetPort: 9098}))
+	objs := append(newTestProxyConfigMaps(map[string]string{"9098": "default/web:80"}), app)
+	r, _ := newTestReconciler(t, objs...)
+
+	if err := r.reconcileProxy(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile proxy: %v", err)
+	}
+	configMap := &corev1.ConfigMap{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: KubeSystemNamespace, Name: IngressTCPConfigMap}, configMap); err != nil {
+		t.Fatal(err)
+	}
+	owners := getProxyOwners(configMap)
+	if owners["9098"].UID != app.UID || owners["9098"].Module != "web" {
+		t.Errorf("expected legacy rule to be owned by the module, got %v", owners)
+	}
+}
+
+func TestReconcileProxyKeepsLegacyRulesNotInSpec(t *testing.T) {
+	// 升级前创建的规则, 升级后module的部分端口发生了变化
+	app := newTestApplication(newTestModule("web", appv1.Proxy{Protocol: "tcp", Port: 8080, TargetPort: 9099}, appv1.Proxy{Protocol: "tcp", Port: 81, TargetPort: 9100}))
+	objs := append(newTestProxyConfigMaps(map[string]string{"9098": "default/web:80", "9100": "default/web:81", "9200": "default/api:80"}), app)
+	r, _ := newTestReconciler(t, objs...)
+
+	for i := 0; i < 2; i++ {
+		if err := r.reconcileProxy(context.TODO(), app); err != nil {
+			t.Fatalf("reconcile proxy: %v", err)
+		}
+	}
+	configMap := getTCPConfigMap(t, r)
+	expected := map[string]string{"9098": "default/web:80", "9099": "default/web:8080", "9100": "default/web:81", "9200": "default/api:80"}
+	if !reflect.DeepEqual(configMap.Data, expected) {
+		t.Errorf("expected legacy rules not in spec to be kept, got %v", configMap.Data)
+	}
+	owners := getProxyOwners(configMap)
+	if len(owners) != 2 || owners["9099"].UID != app.UID || owners["9100"].UID != app.UID {
+		t.Errorf("expected only spec rules to be owned by the module, got %v", owners)
+	}
+
+	// 不需要外部访问的module不认领也不删除指向其服务的规则
+	api := newTestApplication(newTestModule("api"))
+	api.Name = "portal"
+	api.UID = "portal-uid"
+	if err := r.reconcileProxy(context.TODO(), api); err != nil {
+		t.Fatalf("reconcile proxy: %v", err)
+	}
+	if value := getTCPConfigMap(t, r).Data["9200"]; value != "default/api:80" {
+		t.Errorf("expected rule not created by controller to be kept, got %q", value)
+	}
+}
+
+func TestMakeProxyRuleValue(t *testing.T) {
+	cases := []struct {
+		proxy appv1.Proxy
+		want  string
+	}{
+		{appv1.Proxy{Protocol: "tcp", Port: 80, TargetPort: 9098}, "default/web:80"},
+		{appv1.Proxy{Protocol: "tcp", Port: 80, TargetPort: 9098, ProxyProtocolDecode: true}, "default/web:80:PROXY"},
+		{appv1.Proxy{Protocol: "tcp", Port: 80, TargetPort: 9098, ProxyProtocolEncode: true}, "default/web:80::PROXY"},
+		{appv1.Proxy{Protocol: "tcp", Port: 80, TargetPort: 9098, ProxyProtocolDecode: true, ProxyProtocolEncode: true}, "default/web:80:PROXY:PROXY"},
+	}
+	for _, c := range cases {
+		if got := makeProxyRuleValue("default", "web", c.proxy); got != c.want {
+			t.Errorf("makeProxyRuleValue(%+v) = %q, want %q", c.proxy, got, c.want)
+		}
+	}
+}
+
+func TestGetProxyRulesForNameSpaceNameWithProxyProtocol(t *testing.T) {
+	configMap := &corev1.ConfigMap{Data: map[string]string{
+		"9098": "default/web:80:PROXY:PROXY",
+		"9099": "default/web:81::PROXY",
+		"9100": "default/web-admin:80:PROXY",
+	}}
+	rules := GetProxyRulesForNameSpaceName(types.NamespacedName{Namespace: "default", Name: "web"}, configMap)
+	if len(rules) != 2 || rules["9098"] != "default/web:80:PROXY:PROXY" || rules["9099"] != "default/web:81::PROXY" {
+		t.Errorf("unexpected rules %v", rules)
+	}
+}
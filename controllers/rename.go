@@ -0,0 +1,101 @@
+/**
+ * 功能描述: module重命名, 新deployment可用后再迁移proxy规则并删除旧的deployment和svc
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
+	v1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// 查找spec中替换旧deployment的module: 由previousName重命名而来, 或module名称未变但命名方式变更
+func renamedModule(app *appv1.Application, oldDeploy *v1.Deployment) *appv1.Module {
+	oldName := moduleNameOf(oldDeploy)
+	for i := range app.Spec.Modules {
+		module := &app.Spec.Modules[i]
+		if module.Name != oldName && module.PreviousName != oldName {
+			continue
+		}
+		if app.ModuleResourceName(module.Name) != oldDeploy.Name {
+			return module
+		}
+	}
+	return nil
+}
+
+// 判断module是否正在重命名, 即被替换的deployment仍然存在
+func (r *ApplicationReconciler) isRenaming(ctx context.Context, app *appv1.Application, module *appv1.Module) (bool, error) {
+	deploymentList := &v1.DeploymentList{}
+	if err := r.List(ctx, deploymentList, client.InNamespace(app.Namespace), applabels.SelectorForApp(app.Name)); err != nil {
+		log.Error(err, "failed to list deployment by namespace and label.", "namespace", app.Namespace, "label", applabels.AppName)
+		return false, err
+	}
+	for i := range deploymentList.Items {
+		deploy := &deploymentList.Items[i]
+		if metav1.IsControlledBy(deploy, app) && renamedModule(app, deploy) == module {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// 新deployment可用且svc已创建后, 将旧deployment的proxy规则转移给新module, 返回是否可以删除旧的deployment
+func (r *ApplicationReconciler) moveRenamedModule(ctx context.Context, app *appv1.Application, module *appv1.Module, oldDeploy *v1.Deployment) (bool, error) {
+	name := app.ModuleResourceName(module.Name)
+	deploy := &v1.Deployment{}
+	err := r.Get(ctx, types.NamespacedName{Namespace: app.Namespace, Name: name}, deploy)
+	if err != nil && apierrs.IsNotFound(err) {
+		r.recordModuleWaiting(app, module.Name, "waiting for deployment %s to replace %s", name, oldDeploy.Name)
+		return false, nil
+	} else if err != nil {
+		log.Error(err, "failed to get the renamed deployment.", "namespace", app.Namespace, "name", name)
+		return false, err
+	}
+	if !isDeploymentAvailable(deploy) {
+		log.Info("the renamed deployment is not available yet, keep the previous one.", "namespace", app.Namespace, "name", name, "previous", oldDeploy.Name)
+		r.recordModuleWaiting(app, module.Name, "waiting for deployment %s to be available to replace %s", name, oldDeploy.Name)
+		return false, nil
+	}
+
+	// 有端口暴露时需要等待svc创建, 避免proxy规则指向不存在的svc
+	specSvc, err := makeSvcFromDeploy(deploy)
+	if err != nil {
+		return false, err
+	}
+	if len(specSvc.Spec.Ports) > 0 {
+		svc := &corev1.Service{}
+		err = r.Get(ctx, types.NamespacedName{Namespace: app.Namespace, Name: name}, svc)
+		if err != nil && apierrs.IsNotFound(err) {
+			r.recordModuleWaiting(app, module.Name, "waiting for svc %s to replace %s", name, oldDeploy.Name)
+			return false, nil
+		} else if err != nil {
+			log.Error(err, "failed to get the renamed svc.", "namespace", app.Namespace, "name", name)
+			return false, err
+		}
+	}
+
+	if err := r.reconcileModuleProxy(ctx, app, module, newProxyOwner(app, moduleNameOf(oldDeploy))); err != nil {
+		return false, err
+	}
+	r.recordModuleEvent(app, module.Name, corev1.EventTypeNormal, ReasonModuleUpdated, "replaced deployment %s with %s", oldDeploy.Name, name)
+	return true, nil
+}
+
+// 判断deployment的当前版本是否全部可用
+func isDeploymentAvailable(deploy *v1.Deployment) bool {
+	replicas := int32(1)
+	if deploy.Spec.Replicas != nil {
+		replicas = *deploy.Spec.Replicas
+	}
+	return deploy.Status.ObservedGeneration >= deploy.Generation &&
+		deploy.Status.UpdatedReplicas >= replicas &&
+		deploy.Status.AvailableReplicas >= replicas
+}
@@ -0,0 +1,65 @@
+/**
+ * 功能描述: 验证module重命名时新deployment可用后才迁移proxy规则并删除旧的deployment
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+)
+
+func TestRenameModuleWaitsForAvailableDeployment(t *testing.T) {
+	app := newTestApplication(newTestModule("web", appv1.Proxy{Protocol: "tcp", Port: 80, TargetPort: 9098}))
+	objs := append(newTestProxyConfigMaps(nil), app)
+	r, _ := newTestReconciler(t, objs...)
+	reconcileAll := func() {
+		t.Helper()
+		if err := r.reconcileInstance(context.TODO(), app); err != nil {
+			t.Fatalf("reconcile instance: %v", err)
+		}
+		if err := r.reconcileSvc(context.TODO(), app); err != nil {
+			t.Fatalf("reconcile svc: %v", err)
+		}
+		if err := r.reconcileProxy(context.TODO(), app); err != nil {
+			t.Fatalf("reconcile proxy: %v", err)
+		}
+	}
+	reconcileAll()
+
+	app.Spec.Modules[0].Name = "portal"
+	app.Spec.Modules[0].PreviousName = "web"
+	reconcileAll()
+
+	// 新deployment可用前保留旧的deployment和proxy规则
+	if _, err := getTestDeployment(r, "web"); err != nil {
+		t.Fatalf("expected previous deployment to be kept: %v", err)
+	}
+	if value := getTCPConfigMap(t, r).Data["9098"]; value != "default/web:80" {
+		t.Errorf("expected proxy rule to point to the previous svc, got %q", value)
+	}
+
+	deploy, err := getTestDeployment(r, "portal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deploy.Status.UpdatedReplicas = 1
+	deploy.Status.AvailableReplicas = 1
+	if err := r.Status().Update(context.TODO(), deploy); err != nil {
+		t.Fatal(err)
+	}
+	reconcileAll()
+
+	if _, err := getTestDeployment(r, "web"); err == nil {
+		t.Errorf("expected previous deployment to be deleted")
+	}
+	configMap := getTCPConfigMap(t, r)
+	if value := configMap.Data["9098"]; value != "default/portal:80" {
+		t.Errorf("expected proxy rule to be moved to the renamed svc, got %q", value)
+	}
+	if owner := getProxyOwners(configMap)["9098"]; owner.Module != "portal" {
+		t.Errorf("expected proxy rule to be owned by the renamed module, got %v", owner)
+	}
+}
@@ -0,0 +1,131 @@
+/**
+ * 功能描述: 离线将application渲染为控制器会生成的k8s资源清单
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"fmt"
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/yaml"
+)
+
+// 从yaml或json中读取application, 支持以---分隔的多个文档, 未指定namespace时使用defaultNamespace
+func DecodeApplications(reader io.Reader, defaultNamespace string) ([]*appv1.Application, error) {
+	apps := make([]*appv1.Application, 0)
+	decoder := utilyaml.NewYAMLOrJSONDecoder(reader, 4096)
+	for {
+		app := &appv1.Application{}
+		if err := decoder.Decode(app); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		// 跳过空文档
+		if app.Name == "" && len(app.Spec.Modules) == 0 {
+			continue
+		}
+		if app.Kind != "" && app.Kind != "Application" {
+			return nil, fmt.Errorf("unsupported kind %s of %s, only Application can be rendered", app.Kind, app.Name)
+		}
+		if app.Namespace == "" {
+			app.Namespace = defaultNamespace
+		}
+		apps = append(apps, app)
+	}
+	return apps, nil
+}
+
+// 生成控制器会为application创建的deployment、svc、network policy以及ingress tcp/udp configmap片段.
+// 渲染结果不设置owner reference, 可以在没有控制器的集群中直接部署
+func RenderApplication(app *appv1.Application, defaults ModuleDefaults) ([]runtime.Object, error) {
+	return RenderApplications([]*appv1.Application{app}, defaults)
+}
+
+// 渲染多个application. 所有应用的proxy规则合并到同一个tcp/udp configmap中, 输出在最后, 端口冲突时返回错误
+func RenderApplications(apps []*appv1.Application, defaults ModuleDefaults) ([]runtime.Object, error) {
+	objects := make([]runtime.Object, 0)
+	tcpData := make(map[string]string)
+	udpData := make(map[string]string)
+	for _, app := range apps {
+		for i := range app.Spec.Modules {
+			module := &app.Spec.Modules[i]
+			deploy, err := makeModule2Deployment(module, app, defaults)
+			if err != nil {
+				return nil, fmt.Errorf("render application %s/%s: %v", app.Namespace, app.Name, err)
+			}
+			objects = append(objects, deploy)
+
+			svc, err := makeSvcFromDeploy(deploy)
+			if err != nil {
+				return nil, fmt.Errorf("render application %s/%s: %v", app.Namespace, app.Name, err)
+			}
+			// 没有端口暴露的module不生成svc
+			if len(svc.Spec.Ports) > 0 {
+				objects = append(objects, svc)
+			}
+
+			if policy := makeNetworkPolicyFromDeploy(module.AccessMode, deploy); policy != nil {
+				objects = append(objects, policy)
+			}
+
+			tcpRules, udpRules := makeModuleProxyRules(app, module)
+			if err := mergeProxyRules(tcpData, tcpRules, "tcp"); err != nil {
+				return nil, fmt.Errorf("render application %s/%s: %v", app.Namespace, app.Name, err)
+			}
+			if err := mergeProxyRules(udpData, udpRules, "udp"); err != nil {
+				return nil, fmt.Errorf("render application %s/%s: %v", app.Namespace, app.Name, err)
+			}
+		}
+	}
+
+	if len(tcpData) > 0 {
+		objects = append(objects, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: IngressTCPConfigMap, Namespace: KubeSystemNamespace},
+			Data:       tcpData,
+		})
+	}
+	if len(udpData) > 0 {
+		objects = append(objects, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: IngressUDPConfigMap, Namespace: KubeSystemNamespace},
+			Data:       udpData,
+		})
+	}
+	return objects, nil
+}
+
+// 将module的规则合并到configmap的数据中, 端口已被使用时返回错误
+func mergeProxyRules(data, rules map[string]string, protocol string) error {
+	for key, value := range rules {
+		if usedBy, isExist := data[key]; isExist {
+			return fmt.Errorf("the %s port %s is already used by %s", protocol, key, usedBy)
+		}
+		data[key] = value
+	}
+	return nil
+}
+
+// 将资源以---分隔的yaml格式输出, 并补全apiVersion和kind
+func WriteManifests(writer io.Writer, scheme *runtime.Scheme, objects []runtime.Object) error {
+	for _, object := range objects {
+		gvk, err := apiutil.GVKForObject(object, scheme)
+		if err != nil {
+			return err
+		}
+		object.GetObjectKind().SetGroupVersionKind(gvk)
+		data, err := yaml.Marshal(object)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(writer, "---\n%s", data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+/**
+ * 功能描述: 验证离线渲染输出的资源清单
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+)
+
+func TestDecodeApplicationsDefaultsNamespace(t *testing.T) {
+	manifest := `apiVersion: app.dsgkinfo.com/v1
+kind: Application
+metadata:
+  name: wsgw
+spec:
+  userID: 2
+  modules:
+    - name: web
+---
+apiVersion: app.dsgkinfo.com/v1
+kind: Application
+metadata:
+  name: api
+  namespace: prod
+`
+	apps, err := DecodeApplications(strings.NewReader(manifest), "dev")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 2 || apps[0].Namespace != "dev" || apps[1].Namespace != "prod" {
+		t.Fatalf("unexpected applications %v", apps)
+	}
+	if len(apps[0].Spec.Modules) != 1 || apps[0].Spec.Modules[0].Name != "web" {
+		t.Errorf("unexpected modules %v", apps[0].Spec.Modules)
+	}
+}
+
+func TestRenderApplication(t *testing.T) {
+	app := newTestApplication(newTestModule("web", appv1.Proxy{Protocol: "tcp", Port: 80, TargetPort: 9098, ProxyProtocolDecode: true}))
+	objects, err := RenderApplication(app, ModuleDefaults{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// deployment, svc, network policy以及tcp configmap
+	if len(objects) != 4 {
+		t.Fatalf("expected 4 objects, got %d", len(objects))
+	}
+	configMap, ok := objects[3].(*corev1.ConfigMap)
+	if !ok || configMap.Name != IngressTCPConfigMap || configMap.Data["9098"] != "default/web:80:PROXY" {
+		t.Errorf("unexpected tcp configmap %v", objects[3])
+	}
+
+	var out bytes.Buffer
+	if err := WriteManifests(&out, clientgoscheme.Scheme, objects); err != nil {
+		t.Fatal(err)
+	}
+	for _, kind := range []string{"kind: Deployment", "kind: Service", "kind: NetworkPolicy", "kind: ConfigMap"} {
+		if !strings.Contains(out.String(), kind) {
+			t.Errorf("expected %q in rendered manifests:\n%s", kind, out.String())
+		}
+	}
+}
+
+func TestRenderApplicationRejectsDuplicatedProxyPort(t *testing.T) {
+	proxy := appv1.Proxy{Protocol: "tcp", Port: 80, TargetPort: 9098}
+	app := newTestApplication(newTestModule("web", proxy), newTestModule("api", proxy))
+	if _, err := RenderApplication(app, ModuleDefaults{}); err == nil {
+		t.Error("expected duplicated proxy port error")
+	}
+}
+
+func TestRenderApplicationsMergesProxyRules(t *testing.T) {
+	web := newTestApplication(newTestModule("web", appv1.Proxy{Protocol: "tcp", Port: 80, TargetPort: 9098}, appv1.Proxy{Protocol: "udp", Port: 53, TargetPort: 9053}))
+	api := newTestApplication(newTestModule("api", appv1.Proxy{Protocol: "tcp", Port: 80, TargetPort: 9099}))
+	api.Name = "api"
+	api.Namespace = "prod"
+	objects, err := RenderApplications([]*appv1.Application{web, api}, ModuleDefaults{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configMaps := make(map[string]*corev1.ConfigMap)
+	for _, object := range objects {
+		if configMap, ok := object.(*corev1.ConfigMap); ok {
+			if _, isExist := configMaps[configMap.Name]; isExist {
+				t.Errorf("expected only one configmap %s", configMap.Name)
+			}
+			configMaps[configMap.Name] = configMap
+		}
+	}
+	tcp := configMaps[IngressTCPConfigMap]
+	if tcp == nil || len(tcp.Data) != 2 || tcp.Data["9098"] != "default/web:80" || tcp.Data["9099"] != "prod/api:80" {
+		t.Errorf("expected tcp rules of both applications, got %v", tcp)
+	}
+	if udp := configMaps[IngressUDPConfigMap]; udp == nil || udp.Data["9053"] != "default/web:53" {
+		t.Errorf("unexpected udp configmap %v", udp)
+	}
+
+	// 不同应用使用同一个端口时返回错误
+	api.Spec.Modules[0].Proxies[0].TargetPort = 9098
+	if _, err := RenderApplications([]*appv1.Application{web, api}, ModuleDefaults{}); err == nil || !strings.Contains(err.Error(), "prod/api") {
+		t.Errorf("expected port conflict between applications, got %v", err)
+	}
+}
@@ -0,0 +1,27 @@
+/**
+ * 功能描述: 计算调谐完成后重新调谐的时间, 应用处于启动或更新中时定期刷新状态
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"time"
+)
+
+// 应用在启动或滚动更新时, 不依赖deployment事件, 按间隔重新调谐使状态收敛
+func isAppInProgress(status *appv1.ApplicationStatus) bool {
+	return status.Status == "Starting" || status.Status == "Progressing"
+}
+
+// 返回调谐完成后的重新调谐时间, 取延迟删除到期时间和启动中重新调谐间隔中较早的一个, 为0时等待watch事件或全局resync
+func (r *ApplicationReconciler) requeueAfter(app *appv1.Application) time.Duration {
+	next := nextModuleDeletion(&app.Status)
+	if r.ProgressRequeueInterval <= 0 || !isAppInProgress(&app.Status) {
+		return next
+	}
+	if next == 0 || r.ProgressRequeueInterval < next {
+		return r.ProgressRequeueInterval
+	}
+	return next
+}
@@ -0,0 +1,48 @@
+/**
+ * 功能描述: 验证启动和更新中的应用按间隔重新调谐
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestRequeueWhileAppInProgress(t *testing.T) {
+	app := newTestApplication(newTestModule("web"))
+	r, _ := newTestReconciler(t, append(newTestProxyConfigMaps(nil), app)...)
+	r.ProgressRequeueInterval = 10 * time.Second
+	r.RateLimiter = NewRateLimiter(time.Millisecond, time.Second, 100, 100)
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: app.Namespace, Name: app.Name}}
+	reconcile := func() ctrl.Result {
+		t.Helper()
+		result, err := r.Reconcile(req)
+		if err != nil {
+			t.Fatalf("reconcile: %v", err)
+		}
+		return result
+	}
+
+	// deployment尚未创建或没有可用副本时应用为Starting
+	if result := reconcile(); result.RequeueAfter != r.ProgressRequeueInterval {
+		t.Errorf("expected starting app to be requeued after %v, got %v", r.ProgressRequeueInterval, result.RequeueAfter)
+	}
+	if result := reconcile(); result.RequeueAfter != r.ProgressRequeueInterval {
+		t.Errorf("expected starting app to be requeued after %v, got %v", r.ProgressRequeueInterval, result.RequeueAfter)
+	}
+
+	setTestDeploymentStatus(t, r, "web", 1, 1, 1)
+	if result := reconcile(); result.RequeueAfter != 0 {
+		t.Errorf("expected running app to wait for watch events, got %v", result.RequeueAfter)
+	}
+
+	r.ProgressRequeueInterval = 0
+	setTestDeploymentStatus(t, r, "web", 1, 1, 0)
+	if result := reconcile(); result.RequeueAfter != 0 {
+		t.Errorf("expected no requeue when the interval is 0, got %v", result.RequeueAfter)
+	}
+}
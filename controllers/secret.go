@@ -0,0 +1,140 @@
+/**
+ * 功能描述: 对application使用的镜像拉取secret进行调谐
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	"fmt"
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
+	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"reflect"
+)
+
+// 允许复制的secret类型, 只复制镜像仓库的认证信息
+var imagePullSecretTypes = []corev1.SecretType{corev1.SecretTypeDockerConfigJson, corev1.SecretTypeDockercfg}
+
+// 获取应用生效的镜像拉取secret, 控制器默认secret在前
+func imagePullSecretsFor(app *appv1.Application, defaultSecret string) []corev1.LocalObjectReference {
+	secrets := make([]corev1.LocalObjectReference, 0, len(app.Spec.ImagePullSecrets)+1)
+	if defaultSecret != "" {
+		secrets = append(secrets, corev1.LocalObjectReference{Name: defaultSecret})
+	}
+	for _, secret := range app.Spec.ImagePullSecrets {
+		if secret.Name != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+	return secrets
+}
+
+// 将secret注入到pod中, 跳过template中已经指定的secret
+func injectImagePullSecrets(podSpec *corev1.PodSpec, secrets []corev1.LocalObjectReference) {
+	for _, secret := range secrets {
+		isExist := false
+		for _, found := range podSpec.ImagePullSecrets {
+			if found.Name == secret.Name {
+				isExist = true
+				break
+			}
+		}
+		if !isExist {
+			podSpec.ImagePullSecrets = append(podSpec.ImagePullSecrets, secret)
+		}
+	}
+}
+
+// 获取需要从来源namespace复制的secret, 只包括控制器默认secret和允许列表中的secret, 其他secret由应用在自己的namespace中创建
+func copyableImagePullSecrets(app *appv1.Application, defaults ModuleDefaults) []corev1.LocalObjectReference {
+	secrets := make([]corev1.LocalObjectReference, 0)
+	for _, ref := range imagePullSecretsFor(app, defaults.ImagePullSecret) {
+		if ref.Name == defaults.ImagePullSecret || containsString(defaults.ImagePullSecretAllowlist, ref.Name) {
+			secrets = append(secrets, ref)
+		}
+	}
+	return secrets
+}
+
+func isImagePullSecretType(secretType corev1.SecretType) bool {
+	for _, item := range imagePullSecretTypes {
+		if item == secretType {
+			return true
+		}
+	}
+	return false
+}
+
+// 将镜像拉取secret从来源namespace复制到应用所在的namespace. 通过apiReader读取, 不缓存集群中所有的secret
+func (r *ApplicationReconciler) reconcileImagePullSecrets(ctx context.Context, app *appv1.Application) error {
+	sourceNamespace := r.Defaults.ImagePullSecretNamespace
+	if sourceNamespace == "" || sourceNamespace == app.Namespace {
+		return nil
+	}
+	for _, ref := range copyableImagePullSecrets(app, r.Defaults) {
+		source := &corev1.Secret{}
+		err := r.apiReader().Get(ctx, types.NamespacedName{Namespace: sourceNamespace, Name: ref.Name}, source)
+		if err != nil && apierrs.IsNotFound(err) {
+			log.Info("the image pull secret is not found in source namespace. continue...", "namespace", sourceNamespace, "name", ref.Name)
+			continue
+		} else if err != nil {
+			log.Error(err, "failed to get source image pull secret.", "namespace", sourceNamespace, "name", ref.Name)
+			return err
+		}
+		// 来源namespace中的其他类型secret(如service account token, 证书)不允许复制
+		if !isImagePullSecretType(source.Type) {
+			log.Info("the source secret is not an image pull secret, skip copy.", "namespace", sourceNamespace, "name", ref.Name, "type", source.Type)
+			r.Recorder.Event(app, corev1.EventTypeWarning, ReasonSecretRejected, fmt.Sprintf("Secret %s in namespace %s is of type %s and can not be copied as an image pull secret", ref.Name, sourceNamespace, source.Type))
+			continue
+		}
+
+		found := &corev1.Secret{}
+		err = r.apiReader().Get(ctx, types.NamespacedName{Namespace: app.Namespace, Name: ref.Name}, found)
+		if err != nil && apierrs.IsNotFound(err) {
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            ref.Name,
+					Namespace:       app.Namespace,
+					Annotations:     map[string]string{applabels.CopiedFromAnnotation: sourceNamespace},
+					OwnerReferences: []metav1.OwnerReference{newAppOwnerReference(app)},
+				},
+				Type: source.Type,
+				Data: source.Data,
+			}
+			log.Info("the image pull secret is not found and copy it from source namespace.", "namespace", app.Namespace, "name", ref.Name)
+			if err := r.Create(ctx, secret); err != nil {
+				log.Error(err, "failed to create image pull secret.", "namespace", app.Namespace, "name", ref.Name)
+				return err
+			}
+			r.Recorder.Event(app, corev1.EventTypeNormal, ReasonSecretCopied, fmt.Sprintf("Copied image pull secret %s from namespace %s", ref.Name, sourceNamespace))
+			continue
+		} else if err != nil {
+			log.Error(err, "failed to get image pull secret.", "namespace", app.Namespace, "name", ref.Name)
+			return err
+		}
+
+		// 不是由控制器复制的secret, 不做修改
+		if found.Annotations[applabels.CopiedFromAnnotation] != sourceNamespace {
+			continue
+		}
+		ownerRef := newAppOwnerReference(app)
+		if reflect.DeepEqual(found.Data, source.Data) && hasOwnerReference(found.OwnerReferences, ownerRef) {
+			continue
+		}
+		found.Data = source.Data
+		if !hasOwnerReference(found.OwnerReferences, ownerRef) {
+			// 多个应用共用同一个secret, 所有应用删除后才会被回收
+			found.OwnerReferences = append(found.OwnerReferences, ownerRef)
+		}
+		if err := r.Update(ctx, found); err != nil {
+			log.Error(err, "failed to update image pull secret.", "namespace", app.Namespace, "name", ref.Name)
+			return err
+		}
+		log.Info("successful update image pull secret from source namespace.", "namespace", app.Namespace, "name", ref.Name)
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+/**
+ * 功能描述: 验证镜像拉取secret的注入和复制
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newTestSecret(name string, secretType corev1.SecretType, value string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "registry"},
+		Type:       secretType,
+		Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(value)},
+	}
+}
+
+func getTestSecret(r *ApplicationReconciler, name string) (*corev1.Secret, error) {
+	secret := &corev1.Secret{}
+	err := r.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: name}, secret)
+	return secret, err
+}
+
+func TestCopyImagePullSecrets(t *testing.T) {
+	app := newTestApplication()
+	app.Spec.ImagePullSecrets = []corev1.LocalObjectReference{{Name: "shared"}, {Name: "sa-token"}, {Name: "tls"}, {Name: "local"}}
+	objs := []runtime.Object{
+		app,
+		newTestSecret("regcred", corev1.SecretTypeDockerConfigJson, "default"),
+		newTestSecret("shared", corev1.SecretTypeDockercfg, "shared"),
+		newTestSecret("sa-token", corev1.SecretTypeServiceAccountToken, "token"),
+		newTestSecret("tls", corev1.SecretTypeTLS, "key"),
+	}
+	r, recorder := newTestReconciler(t, objs...)
+	r.Defaults = ModuleDefaults{
+		ImagePullSecret:          "regcred",
+		ImagePullSecretNamespace: "registry",
+		ImagePullSecretAllowlist: []string{"shared", "sa-token"},
+	}
+
+	if err := r.reconcileImagePullSecrets(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile image pull secrets: %v", err)
+	}
+	for _, name := range []string{"regcred", "shared"} {
+		secret, err := getTestSecret(r, name)
+		if err != nil {
+			t.Fatalf("expected secret %s to be copied: %v", name, err)
+		}
+		if secret.Annotations[applabels.CopiedFromAnnotation] != "registry" || !hasOwnerReference(secret.OwnerReferences, newAppOwnerReference(app)) {
+			t.Errorf("unexpected copied secret %s: %v", name, secret.ObjectMeta)
+		}
+	}
+	// 不是镜像拉取secret类型或不在允许列表中的secret不会被复制
+	for _, name := range []string{"sa-token", "tls", "local"} {
+		if _, err := getTestSecret(r, name); err == nil {
+			t.Errorf("expected secret %s not to be copied", name)
+		}
+	}
+	expectEvent(t, drainEvents(recorder), "Warning "+ReasonSecretRejected)
+
+	// 来源secret更新后同步到复制的secret, 其他应用引用时添加owner reference
+	source := newTestSecret("regcred", corev1.SecretTypeDockerConfigJson, "")
+	if err := r.Get(context.TODO(), types.NamespacedName{Namespace: "registry", Name: "regcred"}, source); err != nil {
+		t.Fatal(err)
+	}
+	source.Data[corev1.DockerConfigJsonKey] = []byte("rotated")
+	if err := r.Update(context.TODO(), source); err != nil {
+		t.Fatal(err)
+	}
+	other := newTestApplication()
+	other.Name = "portal"
+	other.UID = "portal-uid"
+	if err := r.reconcileImagePullSecrets(context.TODO(), other); err != nil {
+		t.Fatalf("reconcile image pull secrets: %v", err)
+	}
+	secret, err := getTestSecret(r, "regcred")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data[corev1.DockerConfigJsonKey]) != "rotated" {
+		t.Errorf("expected copied secret to be updated, got %q", secret.Data[corev1.DockerConfigJsonKey])
+	}
+	if len(secret.OwnerReferences) != 2 || !hasOwnerReference(secret.OwnerReferences, newAppOwnerReference(other)) {
+		t.Errorf("expected both apps to own the copied secret, got %v", secret.OwnerReferences)
+	}
+}
+
+func TestCopyImagePullSecretsSkipsSecretsNotCopied(t *testing.T) {
+	app := newTestApplication()
+	found := newTestSecret("regcred", corev1.SecretTypeDockerConfigJson, "own")
+	found.Namespace = "default"
+	r, _ := newTestReconciler(t, app, found, newTestSecret("regcred", corev1.SecretTypeDockerConfigJson, "default"))
+	r.Defaults = ModuleDefaults{ImagePullSecret: "regcred", ImagePullSecretNamespace: "registry"}
+
+	if err := r.reconcileImagePullSecrets(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile image pull secrets: %v", err)
+	}
+	secret, err := getTestSecret(r, "regcred")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(secret.Data[corev1.DockerConfigJsonKey]) != "own" || len(secret.OwnerReferences) != 0 {
+		t.Errorf("expected secret created by the user not to be modified, got %v", secret)
+	}
+}
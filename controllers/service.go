@@ -9,9 +9,11 @@ import (
 	"context"
 	"fmt"
 	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
 	v1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"reflect"
@@ -19,21 +21,28 @@ import (
 )
 
 // 对module对应的svc进行调谐
-func (r *ApplicationReconciler) reconcileSvc(app *appv1.Application) error {
+func (r *ApplicationReconciler) reconcileSvc(ctx context.Context, app *appv1.Application) error {
 	for i := range app.Spec.Modules {
 		module := &app.Spec.Modules[i]
 
 		// 根据模块名称查找集群中对应的deployment
 		deploy := &v1.Deployment{}
-		err := r.Get(context.TODO(), types.NamespacedName{Name: module.Name, Namespace: app.Namespace}, deploy)
+		name := app.ModuleResourceName(module.Name)
+		err := r.Get(ctx, types.NamespacedName{Name: name, Namespace: app.Namespace}, deploy)
 		if err != nil && strings.Contains(err.Error(), "not found") {
-			log.Info("the delployment was not created. continue...")
+			log.Info("the deployment was not created. continue...")
+			r.recordModuleWaiting(app, module.Name, "waiting for deployment to create svc")
 			continue
 		} else if err != nil {
-			log.Error(err, "failed to get deploy for svc reconcile.", "namespace", app.Namespace, "name", module.Name)
+			log.Error(err, "failed to get deploy for svc reconcile.", "namespace", app.Namespace, "name", name)
 			return err
 		}
 
+		// 未被接管的deployment不生成svc
+		if !metav1.IsControlledBy(deploy, app) {
+			continue
+		}
+
 		// 根据deployment制作一个svc
 		specSvc, err := makeSvcFromDeploy(deploy)
 		if err != nil {
@@ -45,28 +54,29 @@ func (r *ApplicationReconciler) reconcileSvc(app *appv1.Application) error {
 		foundSvc := &corev1.Service{}
 		if len(specSvc.Spec.Ports) <= 0 {
 			log.Info("the svc is no ports", "namespace", deploy.Namespace, "name", deploy.Name)
-			err = r.Get(context.TODO(), types.NamespacedName{Namespace: deploy.Namespace, Name: deploy.Name}, foundSvc)
+			err = r.Get(ctx, types.NamespacedName{Namespace: deploy.Namespace, Name: deploy.Name}, foundSvc)
 			if err == nil {
-				err = r.Delete(context.TODO(), foundSvc)
+				err = r.Delete(ctx, foundSvc)
 				if err != nil {
 					log.Error(err, "failed to delete the no use svc.", "namespace", deploy.Namespace, "name", deploy.Name)
 					return err
 				}
 				log.Info("delete the no use svc.", "namespace", deploy.Namespace, "name", deploy.Name)
+				r.recordModuleEvent(app, module.Name, corev1.EventTypeNormal, ReasonServiceUpdated, "deleted svc %s since no container port is exposed", deploy.Name)
 			}
 			return nil
 		}
 
 		// 根据namespaceName获取svc, 如果不存在则创建,如果和预定义不一致,则更新
-		err = r.Get(context.TODO(), types.NamespacedName{Namespace: deploy.Namespace, Name: deploy.Name}, foundSvc)
+		err = r.Get(ctx, types.NamespacedName{Namespace: deploy.Namespace, Name: deploy.Name}, foundSvc)
 		if err != nil && apierrs.IsNotFound(err) {
 			if specSvc != nil {
 				log.Info("the svc is not found and create new one.", "namespace", deploy.Namespace, "name", deploy.Name)
-				if err := r.Create(context.TODO(), specSvc); err != nil {
+				if err := r.Create(ctx, specSvc); err != nil {
 					log.Error(err, "failed to create svc.", "namespace", deploy.Namespace, "name", deploy.Name)
 					return err
 				}
-				r.Recorder.Event(specSvc, "Normal", "Created", fmt.Sprintf("Create svc for moudle %s  in %s/%s", deploy.Name, app.Namespace, app.Spec.DisplayName))
+				r.recordModuleEvent(app, module.Name, corev1.EventTypeNormal, ReasonServiceUpdated, "created svc %s", specSvc.Name)
 
 			}
 		} else if err != nil {
@@ -77,12 +87,12 @@ func (r *ApplicationReconciler) reconcileSvc(app *appv1.Application) error {
 			clusterIP := foundSvc.Spec.ClusterIP
 			foundSvc.Spec = specSvc.Spec
 			foundSvc.Spec.ClusterIP = clusterIP
-			err = r.Update(context.TODO(), foundSvc)
+			err = r.Update(ctx, foundSvc)
 			if err != nil {
 				log.Error(err, "failed to update svc", "namespace", deploy.Namespace, "name", deploy.Name)
 				return err
 			}
-			r.Recorder.Event(specSvc, "Normal", "SuccessfulUpdate", fmt.Sprintf("SuccessfulUpdate svc for moudle %s  in %s/%s", deploy.Name, app.Namespace, app.Spec.DisplayName))
+			r.recordModuleEvent(app, module.Name, corev1.EventTypeNormal, ReasonServiceUpdated, "updated svc %s", foundSvc.Name)
 
 		}
 
@@ -93,7 +103,7 @@ func (r *ApplicationReconciler) reconcileSvc(app *appv1.Application) error {
 
 func makeSvcFromDeploy(deploy *v1.Deployment) (*corev1.Service, error) {
 	svc := &corev1.Service{}
-	svc.ObjectMeta.Labels = deploy.Labels
+	svc.ObjectMeta.Labels = copyLabels(deploy.Labels)
 	svc.ObjectMeta.Name = deploy.Name
 	svc.ObjectMeta.Namespace = deploy.Namespace
 	svcPorts := make([]corev1.ServicePort, 0, 1)
@@ -116,7 +126,7 @@ func makeSvcFromDeploy(deploy *v1.Deployment) (*corev1.Service, error) {
 	}
 	svc.Spec.Ports = svcPorts
 	label := make(map[string]string)
-	label["name"] = deploy.Name
+	label[applabels.ServiceSelector] = deploy.Name
 	svc.Spec.Selector = label
 	svc.Spec.Type = corev1.ServiceTypeClusterIP
 	return svc, nil
@@ -9,75 +9,223 @@ import (
 	"context"
 	appv1 "github.com/xm5646/paas-crd-application/api/v1"
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"strings"
 )
 
 // 对应用的状态进行更新
-func (r *ApplicationReconciler) reconcileStatus(app *appv1.Application) error {
+func (r *ApplicationReconciler) reconcileStatus(ctx context.Context, app *appv1.Application) error {
 	// 根据module获取对应的deployment
 	totalNum := int32(0)
 	StoppedNum := int32(0)
 	RollingUpdateNum := int32(0)
 	RunningNum := int32(0)
 	StartingNum := int32(0)
+	reconcileBudgetCondition(app)
+	reconcileResourceNameCondition(app)
 	if len(app.Spec.Modules) == 0 {
+		setModuleStatuses(&app.Status, nil)
 		app.Status.Status = "Stopped"
 		app.Status.RunningModuleNumber = 0
 		app.Status.TotalModuleNumber = 0
-		err := r.Status().Update(context.TODO(), app)
+		err := r.Status().Update(ctx, app)
 		if err != nil {
 			log.Error(err, "failed to update app.", "namespace", app.Namespace, "applicationName", app.Name)
 			return err
 		}
+		r.recordMetrics(app)
 		return nil
 	}
+	previous := make(map[string]appv1.ModuleStatus)
+	for _, module := range app.Status.Modules {
+		previous[module.Name] = module
+	}
+	moduleStatuses := make([]appv1.ModuleStatus, 0, len(app.Spec.Modules))
 	for i := range app.Spec.Modules {
 		module := app.Spec.Modules[i]
 		totalNum += 1
+		moduleStatus := appv1.ModuleStatus{Name: module.Name, ResourceName: app.ModuleResourceName(module.Name)}
+		if found, isExist := previous[module.Name]; isExist && found.Phase != appv1.ModulePhasePendingDeletion {
+			moduleStatus.Image = found.Image
+			moduleStatus.Rollout = found.Rollout
+		}
 		deploy := &appsv1.Deployment{}
-		err := r.Get(context.TODO(), types.NamespacedName{Namespace: app.Namespace, Name: module.Name}, deploy)
+		err := r.Get(ctx, types.NamespacedName{Namespace: app.Namespace, Name: moduleStatus.ResourceName}, deploy)
 		if err != nil && strings.Contains(err.Error(), "not found") {
 			log.Info("not to found the module.", "namespace", app.Namespace, "moduleName", module.Name)
+			moduleStatuses = append(moduleStatuses, moduleStatus)
 			continue
 		} else if err != nil {
 			log.Error(err, "failed to get deployment from cluster.", "namespace", app.Namespace, "moduleName", module.Name)
 			return err
 		}
-		if *deploy.Spec.Replicas == 0 {
+		image := imagesOf(&deploy.Spec.Template.Spec)
+		switch {
+		case *deploy.Spec.Replicas == 0:
 			StoppedNum += 1
-		}
-		if *deploy.Spec.Replicas > 0 && deploy.Status.AvailableReplicas == 0 {
+			moduleStatus.Phase = appv1.ModulePhaseStopped
+		case deploy.Status.AvailableReplicas == 0:
 			StartingNum += 1
-		}
-		if deploy.Status.UpdatedReplicas < *deploy.Spec.Replicas && deploy.Status.Replicas > *deploy.Spec.Replicas {
+			moduleStatus.Phase = appv1.ModulePhaseStarting
+		case deploy.Status.ObservedGeneration < deploy.Generation,
+			!isRolloutComplete(deploy) && (deploy.Status.Replicas > deploy.Status.UpdatedReplicas || isImageChanged(&moduleStatus, image)):
+			// 已有可用副本, 但deployment控制器还没有处理最新的修改, 或仍有旧版本的pod或更新了镜像的副本尚未全部可用;
+			// 已处理的只扩缩容的修改保持Running
 			RollingUpdateNum += 1
-		}
-		if *deploy.Spec.Replicas > 0 && deploy.Status.AvailableReplicas > 0 {
+			moduleStatus.Phase = appv1.ModulePhaseProgressing
+		default:
 			RunningNum += 1
+			moduleStatus.Phase = appv1.ModulePhaseRunning
 		}
-
+		setModuleRollout(&moduleStatus, image)
+		if r.AggregateHealth {
+			health, err := r.moduleHealth(ctx, app, module.Name)
+			if err != nil {
+				return err
+			}
+			moduleStatus.Health = health
+		}
+		moduleStatuses = append(moduleStatuses, moduleStatus)
 	}
+	setModuleStatuses(&app.Status, moduleStatuses)
 	if !app.ObjectMeta.DeletionTimestamp.IsZero() {
 		app.Status.Status = "Deleting"
-	} else if RunningNum > 0 {
-		app.Status.Status = "Running"
-	} else if RunningNum == 0 && StartingNum > 0 {
-		app.Status.Status = "Starting"
 	} else if StoppedNum == totalNum {
 		app.Status.Status = "Stopped"
-	} else {
+	} else if RunningNum+RollingUpdateNum == 0 {
 		app.Status.Status = "Starting"
+	} else if RunningNum+StoppedNum < totalNum {
+		// 有可用的module, 但还有module在启动或滚动更新
+		app.Status.Status = "Progressing"
+	} else {
+		app.Status.Status = "Running"
 	}
 	app.Status.RunningModuleNumber = RunningNum
 	app.Status.TotalModuleNumber = totalNum
 	app.Status.StartingModuleNumber = StartingNum
 	app.Status.RollingUpdateNumber = RollingUpdateNum
 	app.Status.StoppedModuleNumber = StoppedNum
-	err := r.Status().Update(context.TODO(), app)
+	err := r.Status().Update(ctx, app)
 	if err != nil {
 		log.Error(err, "failed to update app.", "namespace", app.Namespace, "applicationName", app.Name)
 		return err
 	}
+	r.recordMetrics(app)
 	return nil
 }
+
+// 判断deployment的滚动更新是否完成: 所有副本都已更新并可用, 且旧版本的pod已全部退出
+func isRolloutComplete(deploy *appsv1.Deployment) bool {
+	return isDeploymentAvailable(deploy) && deploy.Status.Replicas <= deploy.Status.UpdatedReplicas
+}
+
+// 获取pod模板中所有容器的镜像, 多个容器以逗号分隔
+func imagesOf(podSpec *corev1.PodSpec) string {
+	images := make([]string, 0, len(podSpec.Containers))
+	for _, container := range podSpec.Containers {
+		images = append(images, container.Image)
+	}
+	return strings.Join(images, ",")
+}
+
+// 判断deployment的镜像是否与状态中记录的镜像不同, 未记录镜像的新module不视为更新
+func isImageChanged(status *appv1.ModuleStatus, image string) bool {
+	return status.Image != "" && status.Image != image
+}
+
+// 根据module的状态记录滚动更新: 镜像变化后进入Progressing或Starting(Recreate策略)时记录更新前后的镜像和开始时间,
+// 更新完成后记录当前镜像. 只扩缩容或首次启动时不记录滚动更新
+func setModuleRollout(status *appv1.ModuleStatus, image string) {
+	switch status.Phase {
+	case appv1.ModulePhaseRunning, appv1.ModulePhaseStopped:
+		status.Image = image
+		status.Rollout = nil
+	case appv1.ModulePhaseProgressing, appv1.ModulePhaseStarting:
+		if !isImageChanged(status, image) {
+			status.Rollout = nil
+			return
+		}
+		if status.Rollout != nil && status.Rollout.NewImage == image {
+			return
+		}
+		status.Rollout = &appv1.ModuleRollout{OldImage: status.Image, NewImage: image, StartedAt: metav1.Now()}
+	}
+}
+
+// 检查应用的资源总量是否超出budget, 并记录到状态条件中
+func reconcileBudgetCondition(app *appv1.Application) {
+	if app.Spec.Resources == nil || len(app.Spec.Resources.Budget) == 0 {
+		removeCondition(&app.Status, appv1.ConditionBudgetExceeded)
+		return
+	}
+	condition := appv1.ApplicationCondition{
+		Type:   appv1.ConditionBudgetExceeded,
+		Status: corev1.ConditionFalse,
+		Reason: "WithinBudget",
+	}
+	if err := app.Spec.ValidateBudget(); err != nil {
+		condition.Status = corev1.ConditionTrue
+		condition.Reason = "LimitsExceeded"
+		condition.Message = err.Error()
+	}
+	setCondition(&app.Status, condition)
+}
+
+// 检查module生成的资源名称是否合法, 不合法时svc等资源无法创建, 记录到状态条件中
+func reconcileResourceNameCondition(app *appv1.Application) {
+	err := app.ValidateModuleResourceNames()
+	if err == nil {
+		removeCondition(&app.Status, appv1.ConditionInvalidResourceName)
+		return
+	}
+	setCondition(&app.Status, appv1.ApplicationCondition{
+		Type:    appv1.ConditionInvalidResourceName,
+		Status:  corev1.ConditionTrue,
+		Reason:  "InvalidDNSLabel",
+		Message: err.Error(),
+	})
+}
+
+// 设置状态条件, 状态未变化时保留原有的变更时间
+func setCondition(status *appv1.ApplicationStatus, condition appv1.ApplicationCondition) {
+	for i := range status.Conditions {
+		found := &status.Conditions[i]
+		if found.Type != condition.Type {
+			continue
+		}
+		if found.Status == condition.Status {
+			condition.LastTransitionTime = found.LastTransitionTime
+		} else {
+			condition.LastTransitionTime = metav1.Now()
+		}
+		*found = condition
+		return
+	}
+	condition.LastTransitionTime = metav1.Now()
+	status.Conditions = append(status.Conditions, condition)
+}
+
+// 设置spec中module的状态, 保留已从spec中移除但尚未删除的module
+func setModuleStatuses(status *appv1.ApplicationStatus, modules []appv1.ModuleStatus) {
+	for _, module := range status.Modules {
+		if module.Phase == appv1.ModulePhasePendingDeletion {
+			modules = append(modules, module)
+		}
+	}
+	if len(modules) == 0 {
+		modules = nil
+	}
+	status.Modules = modules
+}
+
+func removeCondition(status *appv1.ApplicationStatus, conditionType string) {
+	conditions := make([]appv1.ApplicationCondition, 0, len(status.Conditions))
+	for _, condition := range status.Conditions {
+		if condition.Type != conditionType {
+			conditions = append(conditions, condition)
+		}
+	}
+	status.Conditions = conditions
+}
@@ -0,0 +1,230 @@
+/**
+ * 功能描述: 验证应用状态区分Running和Progressing, 并记录滚动更新
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// 设置deployment的副本状态, 模拟deployment控制器的更新进度
+func setTestDeploymentStatus(t *testing.T, r *ApplicationReconciler, name string, replicas, updated, available int32) {
+	t.Helper()
+	deploy, err := getTestDeployment(r, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deploy.Status.Replicas = replicas
+	deploy.Status.UpdatedReplicas = updated
+	deploy.Status.AvailableReplicas = available
+	if err := r.Status().Update(context.TODO(), deploy); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStatusWaitsForRolloutCompletion(t *testing.T) {
+	app := newTestApplication(newTestModule("web"), newTestModule("api"))
+	r, _ := newTestReconciler(t, append(newTestProxyConfigMaps(nil), app)...)
+	reconcile := func() {
+		t.Helper()
+		if err := r.reconcileInstance(context.TODO(), app); err != nil {
+			t.Fatalf("reconcile instance: %v", err)
+		}
+		if err := r.reconcileStatus(context.TODO(), app); err != nil {
+			t.Fatalf("reconcile status: %v", err)
+		}
+	}
+	reconcile()
+	setTestDeploymentStatus(t, r, "web", 1, 1, 1)
+	setTestDeploymentStatus(t, r, "api", 1, 1, 1)
+	reconcile()
+	if app.Status.Status != "Running" || app.Status.RunningModuleNumber != 2 {
+		t.Fatalf("expected application to be running, got %s %d", app.Status.Status, app.Status.RunningModuleNumber)
+	}
+	oldImage := app.Status.Modules[1].Image
+
+	// 更新镜像, 新副本可用但旧的pod尚未退出
+	app.Spec.Modules[1].Template.Template.Spec.Containers[0].Image = "192.168.31.132/appdeploy/tomcat:8"
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	setTestDeploymentStatus(t, r, "api", 2, 1, 1)
+	reconcile()
+	if app.Status.Status != "Progressing" || app.Status.RollingUpdateNumber != 1 {
+		t.Errorf("expected application to be progressing, got %s %d", app.Status.Status, app.Status.RollingUpdateNumber)
+	}
+	module := app.Status.Modules[1]
+	if module.Phase != appv1.ModulePhaseProgressing || module.Rollout == nil {
+		t.Fatalf("expected rollout in module status, got %v", module)
+	}
+	if module.Rollout.OldImage != oldImage || module.Rollout.NewImage != "192.168.31.132/appdeploy/tomcat:8" || module.Rollout.StartedAt.IsZero() {
+		t.Errorf("unexpected rollout %v", module.Rollout)
+	}
+
+	setTestDeploymentStatus(t, r, "api", 1, 1, 1)
+	reconcile()
+	module = app.Status.Modules[1]
+	if app.Status.Status != "Running" || app.Status.RollingUpdateNumber != 0 || module.Rollout != nil || module.Image != "192.168.31.132/appdeploy/tomcat:8" {
+		t.Errorf("expected rollout to be completed, got %s %v", app.Status.Status, module)
+	}
+}
+
+func TestStatusDoesNotRecordRolloutForScaling(t *testing.T) {
+	app := newTestApplication(newTestModule("web"))
+	r, _ := newTestReconciler(t, append(newTestProxyConfigMaps(nil), app)...)
+	reconcile := func() {
+		t.Helper()
+		if err := r.reconcileInstance(context.TODO(), app); err != nil {
+			t.Fatalf("reconcile instance: %v", err)
+		}
+		if err := r.reconcileStatus(context.TODO(), app); err != nil {
+			t.Fatalf("reconcile status: %v", err)
+		}
+	}
+	reconcile()
+	setTestDeploymentStatus(t, r, "web", 1, 1, 1)
+	reconcile()
+
+	// 只扩容, 新增的副本尚未可用
+	replicas := int32(3)
+	app.Spec.Modules[0].Template.Replicas = &replicas
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	setTestDeploymentStatus(t, r, "web", 3, 3, 1)
+	reconcile()
+	module := app.Status.Modules[0]
+	if app.Status.Status != "Running" || module.Phase != appv1.ModulePhaseRunning || module.Rollout != nil {
+		t.Errorf("expected scaling module to stay running without rollout, got %s %v", app.Status.Status, module)
+	}
+}
+
+func TestStatusRecordsRecreateRollout(t *testing.T) {
+	app := newTestApplication(newTestModule("web"))
+	r, _ := newTestReconciler(t, append(newTestProxyConfigMaps(nil), app)...)
+	reconcile := func() {
+		t.Helper()
+		if err := r.reconcileInstance(context.TODO(), app); err != nil {
+			t.Fatalf("reconcile instance: %v", err)
+		}
+		if err := r.reconcileStatus(context.TODO(), app); err != nil {
+			t.Fatalf("reconcile status: %v", err)
+		}
+	}
+	reconcile()
+	if module := app.Status.Modules[0]; module.Phase != appv1.ModulePhaseStarting || module.Rollout != nil {
+		t.Fatalf("expected new module to start without rollout, got %v", module)
+	}
+	setTestDeploymentStatus(t, r, "web", 1, 1, 1)
+	reconcile()
+	oldImage := app.Status.Modules[0].Image
+
+	// Recreate策略先停止所有旧的pod, 更新期间module没有可用副本
+	app.Spec.Modules[0].Template.Template.Spec.Containers[0].Image = "192.168.31.132/appdeploy/tomcat:8"
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	setTestDeploymentStatus(t, r, "web", 0, 0, 0)
+	reconcile()
+	module := app.Status.Modules[0]
+	if module.Phase != appv1.ModulePhaseStarting || module.Rollout == nil || module.Rollout.OldImage != oldImage || module.Rollout.NewImage != "192.168.31.132/appdeploy/tomcat:8" {
+		t.Fatalf("expected recreate rollout in module status, got %v", module)
+	}
+	startedAt := module.Rollout.StartedAt
+
+	setTestDeploymentStatus(t, r, "web", 1, 1, 0)
+	reconcile()
+	if module := app.Status.Modules[0]; module.Rollout == nil || !module.Rollout.StartedAt.Equal(&startedAt) {
+		t.Errorf("expected rollout to be kept while starting, got %v", module)
+	}
+	setTestDeploymentStatus(t, r, "web", 1, 1, 1)
+	reconcile()
+	if module := app.Status.Modules[0]; module.Phase != appv1.ModulePhaseRunning || module.Rollout != nil || module.Image != "192.168.31.132/appdeploy/tomcat:8" {
+		t.Errorf("expected rollout to be completed, got %v", module)
+	}
+}
+
+func TestStatusWaitsForObservedGeneration(t *testing.T) {
+	app := newTestApplication(newTestModule("web"))
+	r, _ := newTestReconciler(t, append(newTestProxyConfigMaps(nil), app)...)
+	reconcile := func() {
+		t.Helper()
+		if err := r.reconcileInstance(context.TODO(), app); err != nil {
+			t.Fatalf("reconcile instance: %v", err)
+		}
+		if err := r.reconcileStatus(context.TODO(), app); err != nil {
+			t.Fatalf("reconcile status: %v", err)
+		}
+	}
+	reconcile()
+	setTestDeploymentStatus(t, r, "web", 1, 1, 1)
+	reconcile()
+	if app.Status.Status != "Running" {
+		t.Fatalf("expected application to be running, got %s", app.Status.Status)
+	}
+
+	// 修改环境变量后deployment控制器还没有处理, 副本状态仍是修改前的
+	app.Spec.Modules[0].Template.Template.Spec.Containers[0].Env = []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "debug"}}
+	if err := r.reconcileInstance(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile instance: %v", err)
+	}
+	deploy, err := getTestDeployment(r, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// fake client不会增加generation, 这里模拟apiserver的行为
+	deploy.Generation = 2
+	deploy.Status.ObservedGeneration = 1
+	if err := r.Update(context.TODO(), deploy); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reconcileStatus(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile status: %v", err)
+	}
+	module := app.Status.Modules[0]
+	if app.Status.Status != "Progressing" || module.Phase != appv1.ModulePhaseProgressing || module.Rollout != nil {
+		t.Errorf("expected module with stale status to be progressing without rollout, got %s %v", app.Status.Status, module)
+	}
+
+	deploy, err = getTestDeployment(r, "web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	deploy.Status.ObservedGeneration = 2
+	if err := r.Status().Update(context.TODO(), deploy); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.reconcileStatus(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile status: %v", err)
+	}
+	if app.Status.Status != "Running" {
+		t.Errorf("expected application to be running after the generation is observed, got %s", app.Status.Status)
+	}
+}
+
+func TestStatusReportsInvalidResourceName(t *testing.T) {
+	app := newTestApplication(newTestModule("web"))
+	app.Name = strings.Repeat("a", 60)
+	app.Spec.NamingScheme = appv1.NamingSchemeAppModule
+	r, _ := newTestReconciler(t, append(newTestProxyConfigMaps(nil), app)...)
+	if err := r.reconcileStatus(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile status: %v", err)
+	}
+	if len(app.Status.Conditions) != 1 || app.Status.Conditions[0].Type != appv1.ConditionInvalidResourceName || app.Status.Conditions[0].Status != corev1.ConditionTrue {
+		t.Fatalf("expected invalid resource name condition, got %v", app.Status.Conditions)
+	}
+
+	app.Spec.NamingScheme = appv1.NamingSchemeModule
+	if err := r.reconcileStatus(context.TODO(), app); err != nil {
+		t.Fatalf("reconcile status: %v", err)
+	}
+	if len(app.Status.Conditions) != 0 {
+		t.Errorf("expected condition to be removed for valid names, got %v", app.Status.Conditions)
+	}
+}
@@ -0,0 +1,60 @@
+/**
+ * 功能描述: 按应用的userID进行多租户隔离
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	"fmt"
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"strconv"
+	"strings"
+)
+
+// 检查应用所在的namespace是否授权给应用的用户, 未开启限制时直接通过
+func (r *ApplicationReconciler) reconcileUserNamespace(ctx context.Context, app *appv1.Application) (bool, error) {
+	if !r.EnforceUserNamespace {
+		removeCondition(&app.Status, appv1.ConditionNamespaceForbidden)
+		return true, nil
+	}
+	namespace := &corev1.Namespace{}
+	err := r.Get(ctx, types.NamespacedName{Name: app.Namespace}, namespace)
+	if err != nil {
+		log.Error(err, "failed to get namespace.", "namespace", app.Namespace)
+		return false, err
+	}
+	if isNamespaceAllowedForUser(namespace, app.Spec.UserID) {
+		removeCondition(&app.Status, appv1.ConditionNamespaceForbidden)
+		return true, nil
+	}
+
+	message := fmt.Sprintf("the namespace %s is not annotated for user %d", app.Namespace, app.Spec.UserID)
+	log.Info("the namespace is not allowed for the user of app, skip reconcile.", "namespace", app.Namespace, "userID", app.Spec.UserID)
+	r.Recorder.Event(app, corev1.EventTypeWarning, ReasonNamespaceForbidden, message)
+	setCondition(&app.Status, appv1.ApplicationCondition{
+		Type:    appv1.ConditionNamespaceForbidden,
+		Status:  corev1.ConditionTrue,
+		Reason:  "UserNotAllowed",
+		Message: message,
+	})
+	app.Status.Status = "Forbidden"
+	if err := r.Status().Update(ctx, app); err != nil {
+		log.Error(err, "failed to update app.", "namespace", app.Namespace, "applicationName", app.Name)
+		return false, err
+	}
+	r.recordMetrics(app)
+	return false, nil
+}
+
+func isNamespaceAllowedForUser(namespace *corev1.Namespace, userID int) bool {
+	for _, item := range strings.Split(namespace.Annotations[applabels.NamespaceUserIDsAnnotation], ",") {
+		if strings.TrimSpace(item) == strconv.Itoa(userID) {
+			return true
+		}
+	}
+	return false
+}
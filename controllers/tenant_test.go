@@ -0,0 +1,70 @@
+/**
+ * 功能描述: 验证按userID限制应用可部署的namespace
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestIsNamespaceAllowedForUser(t *testing.T) {
+	cases := []struct {
+		annotation string
+		userID     int
+		expected   bool
+	}{
+		{annotation: "", userID: 2, expected: false},
+		{annotation: "2", userID: 2, expected: true},
+		{annotation: "1,2,3", userID: 2, expected: true},
+		{annotation: " 1, 2 ", userID: 2, expected: true},
+		{annotation: "12,22", userID: 2, expected: false},
+		{annotation: "1,3", userID: 2, expected: false},
+	}
+	for _, c := range cases {
+		namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "default",
+			Annotations: map[string]string{applabels.NamespaceUserIDsAnnotation: c.annotation},
+		}}
+		if actual := isNamespaceAllowedForUser(namespace, c.userID); actual != c.expected {
+			t.Errorf("isNamespaceAllowedForUser(%q, %d) = %v, expected %v", c.annotation, c.userID, actual, c.expected)
+		}
+	}
+}
+
+func TestReconcileUserNamespace(t *testing.T) {
+	app := newTestApplication()
+	namespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+	r, recorder := newTestReconciler(t, app, namespace)
+
+	// 未开启限制时不检查namespace
+	if allowed, err := r.reconcileUserNamespace(context.TODO(), app); err != nil || !allowed {
+		t.Fatalf("expected app to be allowed when not enforced, got %v %v", allowed, err)
+	}
+
+	r.EnforceUserNamespace = true
+	if allowed, err := r.reconcileUserNamespace(context.TODO(), app); err != nil || allowed {
+		t.Fatalf("expected app to be forbidden, got %v %v", allowed, err)
+	}
+	if app.Status.Status != "Forbidden" || len(app.Status.Conditions) != 1 || app.Status.Conditions[0].Type != appv1.ConditionNamespaceForbidden {
+		t.Errorf("expected forbidden status and condition, got %v", app.Status)
+	}
+	expectEvent(t, drainEvents(recorder), "Warning "+ReasonNamespaceForbidden)
+
+	namespace.Annotations = map[string]string{applabels.NamespaceUserIDsAnnotation: "1,2"}
+	if err := r.Update(context.TODO(), namespace); err != nil {
+		t.Fatal(err)
+	}
+	if allowed, err := r.reconcileUserNamespace(context.TODO(), app); err != nil || !allowed {
+		t.Fatalf("expected app to be allowed after annotating namespace, got %v %v", allowed, err)
+	}
+	if len(app.Status.Conditions) != 0 {
+		t.Errorf("expected forbidden condition to be removed, got %v", app.Status.Conditions)
+	}
+}
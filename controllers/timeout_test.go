@@ -0,0 +1,79 @@
+/**
+ * 功能描述: 验证调谐超时后返回可重试的错误
+ * @Date: 2026-10-19
+ */
+package controllers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// 模拟卡住的apiserver, 创建资源的请求一直阻塞到context被取消
+type blockingClient struct {
+	client.Client
+}
+
+func (c *blockingClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestReconcileTimeoutIsRetriable(t *testing.T) {
+	r := &ApplicationReconciler{ReconcileTimeout: time.Millisecond}
+	ctx, cancel := r.reconcileContext()
+	defer cancel()
+	if _, isExist := ctx.Deadline(); !isExist {
+		t.Fatalf("expected reconcile context to have a deadline")
+	}
+	<-ctx.Done()
+
+	err := reconcileError(ctx, "instance", ctx.Err())
+	if err == nil || !strings.Contains(err.Error(), "timed out") || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected wrapped timeout error to be returned for retry, got %v", err)
+	}
+
+	r.ReconcileTimeout = 0
+	ctx, cancel = r.reconcileContext()
+	defer cancel()
+	if _, isExist := ctx.Deadline(); isExist {
+		t.Errorf("expected no deadline when the timeout is 0")
+	}
+	origin := errors.New("conflict")
+	if err := reconcileError(ctx, "instance", origin); err != origin {
+		t.Errorf("expected other errors to be returned as they are, got %v", err)
+	}
+}
+
+func TestReconcileReturnsWithinTimeout(t *testing.T) {
+	app := newTestApplication(newTestModule("web"))
+	r, _ := newTestReconciler(t, append(newTestProxyConfigMaps(nil), app)...)
+	r.Client = &blockingClient{Client: r.Client}
+	r.ReconcileTimeout = 50 * time.Millisecond
+
+	done := make(chan error, 1)
+	start := time.Now()
+	go func() {
+		_, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: app.Namespace, Name: app.Name}})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("expected deadline exceeded error, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("expected reconcile to return soon after the deadline, took %v", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("reconcile is still blocked after the deadline")
+	}
+}
@@ -5,6 +5,13 @@
  */
 package controllers
 
+import (
+	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"github.com/xm5646/paas-crd-application/pkg/applabels"
+	v1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
 func containsString(slice []string, s string) bool {
 	for _, item := range slice {
 		if item == s {
@@ -23,3 +30,30 @@ func removeString(slice []string, s string) (result []string) {
 	}
 	return
 }
+
+// 生成指向应用的owner reference, 用于多个应用共享的资源, 不设置controller标记
+func newAppOwnerReference(app *appv1.Application) metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion: appv1.GroupVersion.String(),
+		Kind:       "Application",
+		Name:       app.Name,
+		UID:        app.UID,
+	}
+}
+
+// 获取deployment对应的module名称, 资源名称可能带有应用前缀, 以module标签为准
+func moduleNameOf(deploy *v1.Deployment) string {
+	if name, isExist := deploy.Labels[applabels.ModuleName]; isExist && name != "" {
+		return name
+	}
+	return deploy.Name
+}
+
+func hasOwnerReference(refs []metav1.OwnerReference, ref metav1.OwnerReference) bool {
+	for _, item := range refs {
+		if item.UID == ref.UID {
+			return true
+		}
+	}
+	return false
+}
@@ -8,10 +8,12 @@ require (
 	github.com/hashicorp/golang-lru v0.5.3 // indirect
 	github.com/onsi/ginkgo v1.8.0
 	github.com/onsi/gomega v1.5.0
-	golang.org/x/time v0.0.0-20191024005414-555d28b269f0 // indirect
+	github.com/prometheus/client_golang v0.9.2
+	golang.org/x/time v0.0.0-20191024005414-555d28b269f0
 	k8s.io/api v0.16.4
 	k8s.io/apimachinery v0.16.4
 	k8s.io/client-go v0.16.4
 	k8s.io/kube-openapi v0.0.0-20191107075043-30be4d16710a // indirect
 	sigs.k8s.io/controller-runtime v0.4.0
+	sigs.k8s.io/yaml v1.1.0
 )
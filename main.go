@@ -18,8 +18,13 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	appv1 "github.com/xm5646/paas-crd-application/api/v1"
+	"io"
+	"io/ioutil"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/xm5646/paas-crd-application/controllers"
 
@@ -43,11 +48,74 @@ func init() {
 }
 
 func main() {
+	// 离线子命令, 不启动manager
+	if len(os.Args) > 1 {
+		subcommands := map[string]func([]string) error{"render": render, "adopt": adopt}
+		if subcommand, isExist := subcommands[os.Args[1]]; isExist {
+			if err := subcommand(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "%s failed: %v\n", os.Args[1], err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	var metricsAddr string
 	var enableLeaderElection bool
+	var enableWebhook bool
+	var enforceUserNamespace bool
+	var dryRun bool
+	var aggregateHealth bool
+	var watchConfigChanges bool
+	var reconcileTimeout time.Duration
+	var progressRequeueInterval time.Duration
+	var resyncPeriod time.Duration
+	var maxConcurrentReconciles int
+	var rateLimitBaseDelay time.Duration
+	var rateLimitMaxDelay time.Duration
+	var rateLimitQPS float64
+	var rateLimitBurst int
+	var imageRegistry string
+	var imagePullSecret string
+	var imagePullSecretNamespace string
+	var imagePullSecretAllowlist string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
+	flag.BoolVar(&enableWebhook, "enable-webhook", false,
+		"Enable the validating webhook for applications. The serving certificates must be mounted when enabled.")
+	flag.BoolVar(&enforceUserNamespace, "enforce-user-namespace", false,
+		"Only reconcile applications in namespaces whose app.dsgkinfo.com/userIDs annotation contains the application userID.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Compute and log the creates, updates and deletes of every reconcile as Planned events without writing anything to the cluster.")
+	flag.BoolVar(&aggregateHealth, "aggregate-module-health", false,
+		"Report the ready pods and the last readiness change of every module in status.modules[].health. Lists the pods of every module from the API server on each reconcile.")
+	flag.BoolVar(&watchConfigChanges, "watch-config-changes", false,
+		"Watch ConfigMaps and Secrets to roll out modules with rolloutOnConfigChange as soon as a referenced config changes. Caches all ConfigMaps and Secrets of the cluster. Without it, such modules get a ConfigNotWatched warning event and roll out on the next reconcile.")
+	flag.DurationVar(&reconcileTimeout, "reconcile-timeout", time.Minute,
+		"The deadline of a single reconcile. API calls still running are cancelled and the application is retried with backoff. 0 means no deadline.")
+	flag.DurationVar(&progressRequeueInterval, "progress-requeue-interval", 10*time.Second,
+		"The interval to reconcile Starting and Progressing applications again, so that their status converges even if a deployment event is missed. 0 disables it.")
+	flag.DurationVar(&resyncPeriod, "resync-period", 10*time.Hour,
+		"The period to resync the cache and reconcile all applications again.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1,
+		"The number of applications reconciled concurrently.")
+	flag.DurationVar(&rateLimitBaseDelay, "rate-limit-base-delay", 5*time.Millisecond,
+		"The delay before retrying a failed application for the first time. It doubles on every further failure.")
+	flag.DurationVar(&rateLimitMaxDelay, "rate-limit-max-delay", 1000*time.Second,
+		"The maximum delay before retrying a failed application.")
+	flag.Float64Var(&rateLimitQPS, "rate-limit-qps", 10,
+		"The overall number of retries of failed applications per second.")
+	flag.IntVar(&rateLimitBurst, "rate-limit-burst", 100,
+		"The overall burst of retries of failed applications.")
+	flag.StringVar(&imageRegistry, "image-registry", "",
+		"The registry host to rewrite every module container image to. Can be overridden by spec.imageRegistry of each application.")
+	flag.StringVar(&imagePullSecret, "image-pull-secret", "",
+		"The default image pull secret injected into every module pod.")
+	flag.StringVar(&imagePullSecretNamespace, "image-pull-secret-namespace", "",
+		"The namespace to copy image pull secrets from into the application namespace. Secrets are not copied if empty.")
+	flag.StringVar(&imagePullSecretAllowlist, "image-pull-secret-allowlist", "",
+		"Comma separated names of the secrets besides --image-pull-secret that applications may copy from --image-pull-secret-namespace through spec.imagePullSecrets.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(func(o *zap.Options) {
@@ -59,6 +127,7 @@ func main() {
 		MetricsBindAddress: metricsAddr,
 		LeaderElection:     enableLeaderElection,
 		Port:               9443,
+		SyncPeriod:         &resyncPeriod,
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
@@ -70,11 +139,34 @@ func main() {
 		Log:      ctrl.Log.WithName("controllers").WithName("Application"),
 		Scheme:   mgr.GetScheme(),
 		Recorder: mgr.GetEventRecorderFor("application-controller"),
+
+		Defaults: controllers.ModuleDefaults{
+			ImageRegistry:            imageRegistry,
+			ImagePullSecret:          imagePullSecret,
+			ImagePullSecretNamespace: imagePullSecretNamespace,
+			ImagePullSecretAllowlist: splitNames(imagePullSecretAllowlist),
+		},
+		EnforceUserNamespace:    enforceUserNamespace,
+		DryRun:                  dryRun,
+		AggregateHealth:         aggregateHealth,
+		WatchConfigChanges:      watchConfigChanges,
+		ReconcileTimeout:        reconcileTimeout,
+		ProgressRequeueInterval: progressRequeueInterval,
+
+		MaxConcurrentReconciles: maxConcurrentReconciles,
+		RateLimiter:             controllers.NewRateLimiter(rateLimitBaseDelay, rateLimitMaxDelay, rateLimitQPS, rateLimitBurst),
+		APIReader:               mgr.GetAPIReader(),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Application")
 		os.Exit(1)
 	}
 
+	if enableWebhook {
+		if err = (&appv1.Application{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Application")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
@@ -83,3 +175,103 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// 离线渲染application, 输出控制器会生成的deployment、svc、network policy以及ingress tcp/udp configmap片段
+// 用法: manager render -f app.yaml [--namespace default] [--image-registry host] [--image-pull-secret name]
+func render(args []string) error {
+	var file string
+	var namespace string
+	var imageRegistry string
+	var imagePullSecret string
+	flags := flag.NewFlagSet("render", flag.ExitOnError)
+	flags.StringVar(&file, "f", "", "The application manifest to render, - for stdin.")
+	flags.StringVar(&namespace, "namespace", "default", "The namespace of applications without metadata.namespace.")
+	flags.StringVar(&imageRegistry, "image-registry", "", "Same as the image-registry flag of the manager.")
+	flags.StringVar(&imagePullSecret, "image-pull-secret", "", "Same as the image-pull-secret flag of the manager.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if file == "" {
+		return fmt.Errorf("the application manifest must be specified by -f")
+	}
+
+	reader, err := openManifest(file)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	apps, err := controllers.DecodeApplications(reader, namespace)
+	if err != nil {
+		return err
+	}
+	defaults := controllers.ModuleDefaults{
+		ImageRegistry:   imageRegistry,
+		ImagePullSecret: imagePullSecret,
+	}
+	// 所有应用的proxy规则合并到同一个configmap中输出
+	objects, err := controllers.RenderApplications(apps, defaults)
+	if err != nil {
+		return err
+	}
+	return controllers.WriteManifests(os.Stdout, scheme, objects)
+}
+
+// 根据已有的deployment和svc生成开启接管的application, 应用到集群后控制器为其设置controller reference和标签
+// 用法: kubectl get deploy,svc a b -o yaml | manager adopt -f - --name app [--namespace default] [--user-id 2]
+func adopt(args []string) error {
+	var file string
+	var name string
+	var namespace string
+	var userID int
+	flags := flag.NewFlagSet("adopt", flag.ExitOnError)
+	flags.StringVar(&file, "f", "", "The deployment and service manifests to adopt, - for stdin.")
+	flags.StringVar(&name, "name", "", "The name of the generated application.")
+	flags.StringVar(&namespace, "namespace", "", "The namespace of the generated application. Defaults to the namespace of the deployments.")
+	flags.IntVar(&userID, "user-id", 0, "The userID of the generated application.")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if file == "" || name == "" {
+		return fmt.Errorf("the manifests and application name must be specified by -f and --name")
+	}
+
+	reader, err := openManifest(file)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	deploys, svcs, err := controllers.DecodeWorkloads(reader)
+	if err != nil {
+		return err
+	}
+	if len(deploys) == 0 {
+		return fmt.Errorf("no deployment found in %s", file)
+	}
+	app, warnings, err := controllers.BuildApplicationFromWorkloads(name, namespace, userID, deploys, svcs)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+	return controllers.WriteManifests(os.Stdout, scheme, []runtime.Object{app})
+}
+
+// 打开清单文件, -表示标准输入
+func openManifest(file string) (io.ReadCloser, error) {
+	if file == "-" {
+		return ioutil.NopCloser(os.Stdin), nil
+	}
+	return os.Open(file)
+}
+
+// 拆分逗号分隔的名称列表, 忽略空项
+func splitNames(value string) []string {
+	names := make([]string, 0)
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,58 @@
+/**
+ * 功能描述: 控制器、命令行工具和webhook共用的标签和注解定义, 以及按标签选择资源的方法
+ * @Date: 2026-10-19
+ */
+package applabels
+
+import (
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// 控制器添加到生成资源上的标签
+const (
+	AppName        = "app.dsgkinfo.com/appName"
+	ModuleName     = "app.dsgkinfo.com/moduleName"
+	PodType        = "app.dsgkinfo.com/podType"
+	UserID         = "app.dsgkinfo.com/userID"
+	DeploymentType = "app.dsgkinfo.com/deploymentType"
+	// svc的selector使用的pod标签
+	ServiceSelector = "name"
+	// kubernetes推荐的通用标签
+	K8sName        = "app.kubernetes.io/name"
+	K8sInstance    = "app.kubernetes.io/instance"
+	K8sPartOf      = "app.kubernetes.io/part-of"
+	K8sManagedBy   = "app.kubernetes.io/managed-by"
+	ManagedByValue = "application-controller"
+)
+
+// 控制器读取和写入的注解
+const (
+	// 应用的显示名称, 可能包含中文等不能作为标签值的字符, 只记录在注解中
+	DisplayNameAnnotation = "app.dsgkinfo.com/displayName"
+	// 值为true时, 控制器接管与module同名且没有controller的deployment和svc
+	AdoptAnnotation = "app.dsgkinfo.com/adopt"
+	// 记录module的删除保护设置, module从spec中移除后以此判断是否需要确认删除
+	DeletionProtectionAnnotation = "app.dsgkinfo.com/deletionProtection"
+	// module从spec中移除并缩容到0的时间
+	PendingDeletionAnnotation = "app.dsgkinfo.com/pendingDeletionSince"
+	// 值为true时立即删除待删除的module
+	ConfirmDeletionAnnotation = "app.dsgkinfo.com/confirmDeletion"
+	// 记录proxy规则归属的注解, 值为端口到归属信息的json
+	ProxyOwnersAnnotation = "app.dsgkinfo.com/proxyOwners"
+	// pod模板上记录module引用的ConfigMap和Secret内容的校验和, 配置变化时触发滚动更新
+	ConfigChecksumAnnotation = "app.dsgkinfo.com/configChecksum"
+	// 标记由控制器复制的secret, 值为来源namespace
+	CopiedFromAnnotation = "app.dsgkinfo.com/copiedFrom"
+	// namespace授权的用户列表, 多个userID以逗号分隔
+	NamespaceUserIDsAnnotation = "app.dsgkinfo.com/userIDs"
+)
+
+// 选择应用生成的所有资源
+func SelectorForApp(appName string) client.MatchingLabels {
+	return client.MatchingLabels{AppName: appName}
+}
+
+// 选择应用中某个module生成的资源
+func SelectorForModule(appName, module string) client.MatchingLabels {
+	return client.MatchingLabels{AppName: appName, ModuleName: module}
+}